@@ -0,0 +1,119 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"github.com/web3tea/piecehub/config"
+	"github.com/web3tea/piecehub/internal/logging"
+	"github.com/web3tea/piecehub/storage"
+)
+
+var ErrReloadUnsupported = errors.New("reload not supported without a config file")
+
+// Reload loads the configuration again and applies it: storages are added,
+// removed or recreated as needed, tokens and client certificate mappings
+// are rotated and rate limits, job limits and retention replaced. Listener
+// address, timeouts, TLS file paths, the job state dir, the metadata dir
+// and audit log settings require a restart.
+func (h *Handler) Reload() (*storage.UpdateResult, error) {
+	if h.loader == nil {
+		return nil, ErrReloadUnsupported
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	cfg, err := h.loader()
+	if err != nil {
+		return nil, fmt.Errorf("load config: %w", err)
+	}
+
+	res, err := h.store.Update(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("update storages: %w", err)
+	}
+
+	h.auth.Update(&cfg.Server)
+	h.limiter.Update(&cfg.Limits)
+	h.admission.Update(&cfg.Limits)
+	h.jobs.Update(&cfg.Jobs)
+	h.reaper.Update(&cfg.Retention)
+
+	if needsRestart(h.cfg, cfg) {
+		slog.Warn("server address, timeouts, TLS files, job state, metadata dir or audit log changed, restart required to apply")
+	}
+	h.cfg = cfg
+
+	slog.Info("config reloaded", "added", res.Added, "removed", res.Removed, "updated", res.Updated)
+	return res, nil
+}
+
+func needsRestart(old, new *config.Config) bool {
+	return old.Server.Address != new.Server.Address ||
+		old.Server.ReadTimeout != new.Server.ReadTimeout ||
+		old.Server.WriteTimeout != new.Server.WriteTimeout ||
+		old.Server.TLSCert != new.Server.TLSCert ||
+		old.Server.TLSKey != new.Server.TLSKey ||
+		old.Server.ClientCA != new.Server.ClientCA ||
+		old.Jobs.StateDir != new.Jobs.StateDir ||
+		old.Metadata.Dir != new.Metadata.Dir ||
+		old.Audit != new.Audit
+}
+
+func (h *Handler) handleReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	res, err := h.Reload()
+	if errors.Is(err, ErrReloadUnsupported) {
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+type retentionRequest struct {
+	DryRun bool `json:"dryRun"`
+}
+
+// handleRetention starts a retention sweep job, returned right away. An
+// empty body starts a regular sweep.
+func (h *Handler) handleRetention(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req retentionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	job, err := h.reaper.Start(context.WithoutCancel(r.Context()), req.DryRun)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	logging.AddFields(r.Context(), "job", job.ID)
+	auditEvent(r.Context()).Job = job.ID
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/jobs/"+job.ID)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(&job)
+}
@@ -0,0 +1,239 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/web3tea/piecehub/config"
+)
+
+var (
+	errQueueFull    = errors.New("transfer queue full")
+	errQueueTimeout = errors.New("timed out waiting for a transfer slot")
+)
+
+// admissionRetryAfter is the Retry-After value, in seconds, sent with 503.
+const admissionRetryAfter = "5"
+
+// gate is a counting semaphore with a bounded wait queue. Its limit can
+// be changed while transfers hold slots; a limit of 0 admits everything.
+type gate struct {
+	mu       sync.Mutex
+	limit    int
+	active   int
+	maxQueue int64
+	queued   atomic.Int64
+	// wake is closed, and replaced, whenever a slot may have opened up
+	wake chan struct{}
+}
+
+func newGate(limit, maxQueue int) *gate {
+	return &gate{limit: limit, maxQueue: int64(maxQueue), wake: make(chan struct{})}
+}
+
+// resize changes the limits. Transfers over a lowered limit keep their
+// slots; new ones wait until enough have been released.
+func (g *gate) resize(limit, maxQueue int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.limit == limit && g.maxQueue == int64(maxQueue) {
+		return
+	}
+	g.limit, g.maxQueue = limit, int64(maxQueue)
+	g.notify()
+}
+
+// tryAcquire takes a slot if one is free, or returns the channel to wait on
+// for the next chance.
+func (g *gate) tryAcquire() (bool, <-chan struct{}) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.limit <= 0 || g.active < g.limit {
+		g.active++
+		return true, nil
+	}
+	return false, g.wake
+}
+
+func (g *gate) acquire(ctx context.Context, timeout time.Duration) (func(), error) {
+	ok, wake := g.tryAcquire()
+	if ok {
+		return g.release, nil
+	}
+
+	g.mu.Lock()
+	maxQueue := g.maxQueue
+	g.mu.Unlock()
+	if g.queued.Add(1) > maxQueue {
+		g.queued.Add(-1)
+		return nil, errQueueFull
+	}
+	defer g.queued.Add(-1)
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-wake:
+		case <-timer.C:
+			return nil, errQueueTimeout
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if ok, wake = g.tryAcquire(); ok {
+			return g.release, nil
+		}
+	}
+}
+
+func (g *gate) release() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.active--
+	g.notify()
+}
+
+// notify wakes the waiters. The caller holds g.mu.
+func (g *gate) notify() {
+	close(g.wake)
+	g.wake = make(chan struct{})
+}
+
+// GateStatus reports the usage of one transfer gate.
+type GateStatus struct {
+	Limit  int   `json:"limit"`
+	Active int   `json:"active"`
+	Queued int64 `json:"queued"`
+}
+
+func (g *gate) status() GateStatus {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return GateStatus{Limit: g.limit, Active: g.active, Queued: g.queued.Load()}
+}
+
+// Admission bounds concurrent transfers globally and per storage backend.
+type Admission struct {
+	mu         sync.Mutex
+	cfg        config.LimitsConfig
+	global     *gate
+	perStorage map[string]*gate
+}
+
+func NewAdmission(cfg *config.LimitsConfig) *Admission {
+	a := &Admission{}
+	a.Update(cfg)
+	return a
+}
+
+// Update applies new limits. Gates are resized in place, so transfers
+// holding a slot keep counting against the new limits, and unchanged
+// limits leave the gates alone.
+func (a *Admission) Update(cfg *config.LimitsConfig) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.cfg = *cfg
+	if a.global == nil {
+		a.global = newGate(cfg.MaxTransfers, cfg.MaxQueue)
+		a.perStorage = make(map[string]*gate)
+		return
+	}
+	a.global.resize(cfg.MaxTransfers, cfg.MaxQueue)
+	for _, g := range a.perStorage {
+		g.resize(cfg.MaxStorageTransfers, cfg.MaxQueue)
+	}
+}
+
+func (a *Admission) storageGate(storage string) *gate {
+	g, ok := a.perStorage[storage]
+	if !ok {
+		g = newGate(a.cfg.MaxStorageTransfers, a.cfg.MaxQueue)
+		a.perStorage[storage] = g
+	}
+	return g
+}
+
+// Acquire waits for a per-storage and then a global slot. The returned
+// func releases both. Global slots are only taken once the storage has
+// room, so transfers queued on a busy storage do not starve the others.
+func (a *Admission) Acquire(ctx context.Context, storage string) (func(), error) {
+	a.mu.Lock()
+	global, perStorage := a.global, a.storageGate(storage)
+	timeout := time.Duration(a.cfg.QueueTimeout) * time.Second
+	a.mu.Unlock()
+
+	releaseStorage, err := perStorage.acquire(ctx, timeout)
+	if err != nil {
+		return nil, err
+	}
+	releaseGlobal, err := global.acquire(ctx, timeout)
+	if err != nil {
+		releaseStorage()
+		return nil, err
+	}
+	return func() {
+		releaseGlobal()
+		releaseStorage()
+	}, nil
+}
+
+// AdmissionStatus is served on /status and published as an expvar.
+type AdmissionStatus struct {
+	Transfers GateStatus            `json:"transfers"`
+	Storages  map[string]GateStatus `json:"storages"`
+}
+
+func (a *Admission) Status() AdmissionStatus {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	st := AdmissionStatus{
+		Transfers: a.global.status(),
+		Storages:  make(map[string]GateStatus, len(a.perStorage)),
+	}
+	for name, g := range a.perStorage {
+		st.Storages[name] = g.status()
+	}
+	return st
+}
+
+// admit acquires a transfer slot for storage, writing a 503 response when
+// none is available. ok is false if the request was rejected.
+func (h *Handler) admit(w http.ResponseWriter, r *http.Request, storage string) (release func(), ok bool) {
+	release, err := h.admission.Acquire(r.Context(), storage)
+	if err != nil {
+		w.Header().Set("Retry-After", admissionRetryAfter)
+		http.Error(w, "Service Unavailable - "+err.Error(), http.StatusServiceUnavailable)
+		return nil, false
+	}
+	return release, true
+}
+
+var publishOnce sync.Once
+
+// publish exposes the admission status on /debug/vars. Only the first
+// admission is published since expvar names are process-global.
+func (a *Admission) publish() {
+	publishOnce.Do(func() {
+		expvar.Publish("piecehub_admission", expvar.Func(func() any {
+			return a.Status()
+		}))
+	})
+}
+
+func (h *Handler) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.admission.Status())
+}
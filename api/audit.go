@@ -0,0 +1,150 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/web3tea/piecehub/internal/audit"
+	"github.com/web3tea/piecehub/internal/logging"
+)
+
+type auditKey struct{}
+
+// auditEvent returns the audit event of the request in ctx for handlers to
+// add details to, or a discarded event on routes that are not audited.
+func auditEvent(ctx context.Context) *audit.Event {
+	if e, ok := ctx.Value(auditKey{}).(*audit.Event); ok {
+		return e
+	}
+	return &audit.Event{}
+}
+
+// audited records requests to next in the audit log as action, with the
+// caller, the piece named by the id parameter or path and the outcome.
+func (h *Handler) audited(action string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		actor := audit.Actor{
+			ClientIP:  clientIP(r),
+			RequestID: logging.RequestID(r.Context()),
+		}
+		if id := IdentityFromContext(r.Context()); id != nil {
+			actor.Identity = id.Name
+		}
+		ctx := audit.WithActor(r.Context(), actor)
+		e := audit.NewEvent(ctx, action)
+		e.Method = r.Method
+		e.Path = r.URL.Path
+		e.Piece = r.URL.Query().Get("id")
+		if e.Piece == "" {
+			e.Piece = r.PathValue("cid")
+		}
+		e.Storage = r.URL.Query().Get("storage")
+
+		ctx = context.WithValue(ctx, auditKey{}, e)
+		lw := &logWriter{ResponseWriter: w}
+		next(lw, r.WithContext(ctx))
+
+		e.Status = lw.status()
+		e.Outcome = outcome(e.Status)
+		h.audit.Log(e)
+	}
+}
+
+// At most authDenialBurst denied requests are logged per
+// authDenialWindow, so that a flood of bad credentials cannot fill the
+// disk. The rest are counted and logged as one event.
+const (
+	authDenialWindow = time.Minute
+	authDenialBurst  = 20
+)
+
+// authDenials counts the denied requests of the current window. Denials
+// over the burst are reported as one count when the window ends, or when
+// flushed on shutdown.
+type authDenials struct {
+	mu      sync.Mutex
+	start   time.Time
+	logged  int
+	dropped int
+	timer   *time.Timer
+}
+
+// allow reports whether a denial at now is logged on its own. Otherwise it
+// is counted, and report receives the count once the window ends.
+func (d *authDenials) allow(now time.Time, report func(dropped int)) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if now.Sub(d.start) >= authDenialWindow {
+		d.start, d.logged = now, 0
+	}
+	if d.logged < authDenialBurst {
+		d.logged++
+		return true
+	}
+	if d.dropped == 0 {
+		d.timer = time.AfterFunc(d.start.Add(authDenialWindow).Sub(now), func() { d.flush(report) })
+	}
+	d.dropped++
+	return false
+}
+
+// flush reports the denials counted but not logged yet.
+func (d *authDenials) flush(report func(dropped int)) {
+	d.mu.Lock()
+	dropped := d.dropped
+	d.dropped = 0
+	if d.timer != nil {
+		d.timer.Stop()
+		d.timer = nil
+	}
+	d.mu.Unlock()
+	if dropped > 0 {
+		report(dropped)
+	}
+}
+
+func (h *Handler) reportDenials(dropped int) {
+	h.audit.Log(&audit.Event{
+		Action:  "auth",
+		Outcome: audit.Denied,
+		Status:  http.StatusUnauthorized,
+		Count:   dropped,
+		Error:   "denied requests over the audit limit",
+	})
+}
+
+// auditUnauthorized records requests rejected for a missing or invalid
+// token or certificate, up to authDenialBurst per window.
+func (h *Handler) auditUnauthorized(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lw := &logWriter{ResponseWriter: w}
+		next.ServeHTTP(lw, r)
+		if lw.status() != http.StatusUnauthorized {
+			return
+		}
+		if !h.denials.allow(time.Now(), h.reportDenials) {
+			return
+		}
+		h.audit.Log(&audit.Event{
+			Action:    "auth",
+			Outcome:   audit.Denied,
+			ClientIP:  clientIP(r),
+			RequestID: logging.RequestID(r.Context()),
+			Method:    r.Method,
+			Path:      r.URL.Path,
+			Status:    http.StatusUnauthorized,
+		})
+	})
+}
+
+func outcome(status int) string {
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return audit.Denied
+	case status >= 400:
+		return audit.Failure
+	}
+	return audit.Success
+}
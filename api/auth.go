@@ -1,30 +1,99 @@
 package api
 
 import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
 	"net/http"
 	"strings"
+	"sync"
+
+	"github.com/web3tea/piecehub/config"
+	"github.com/web3tea/piecehub/internal/logging"
+)
+
+const (
+	PermRead  = "read"
+	PermWrite = "write"
+	PermAdmin = "admin"
 )
 
+const anonymous = "anonymous"
+
+// allPermissions is granted to token holders.
+var allPermissions = []string{PermRead, PermWrite, PermAdmin}
+
+// Identity is the authenticated caller of a request.
+type Identity struct {
+	Name        string
+	Permissions []string
+}
+
+func (id *Identity) Has(perm string) bool {
+	for _, p := range id.Permissions {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+type identityKey struct{}
+
+// IdentityFromContext returns the identity set by Authenticator.
+func IdentityFromContext(ctx context.Context) *Identity {
+	id, _ := ctx.Value(identityKey{}).(*Identity)
+	return id
+}
+
 type Authenticator struct {
-	tokens  map[string]struct{}
-	enabled bool
+	tokens      *tokenSet
+	clientCerts map[string][]string
+	anonymous   []string
+	enabled     bool
+	mu          sync.RWMutex
 }
 
-func NewAuthenticator(tokens []string) *Authenticator {
-	if len(tokens) == 0 {
-		return &Authenticator{enabled: false}
+func NewAuthenticator(cfg *config.ServerConfig) *Authenticator {
+	a := &Authenticator{}
+	a.Update(cfg)
+	return a
+}
+
+// Update replaces the accepted tokens and client certificate mappings.
+// Requests already past the authenticator are not affected.
+func (a *Authenticator) Update(cfg *config.ServerConfig) {
+	tokens := newTokenSet(cfg.Tokens)
+	certMap := make(map[string][]string)
+	for _, cc := range cfg.ClientCerts {
+		certMap[cc.Subject] = cc.Permissions
 	}
-	tokenMap := make(map[string]struct{})
-	for _, token := range tokens {
-		tokenMap[token] = struct{}{}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.tokens = tokens
+	a.clientCerts = certMap
+	a.anonymous = []string{PermRead}
+	if len(cfg.AnonymousPermissions) > 0 {
+		a.anonymous = cfg.AnonymousPermissions
 	}
-	return &Authenticator{tokens: tokenMap, enabled: true}
+	a.enabled = tokens.len() > 0 || len(certMap) > 0
 }
 
 func (a *Authenticator) Authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if !a.enabled {
-			next.ServeHTTP(w, r)
+		a.mu.RLock()
+		tokens, clientCerts, anonPerms, enabled := a.tokens, a.clientCerts, a.anonymous, a.enabled
+		a.mu.RUnlock()
+
+		if !enabled {
+			next.ServeHTTP(w, withIdentity(r, &Identity{Name: anonymous, Permissions: anonPerms}))
+			return
+		}
+
+		if id := certIdentity(r, clientCerts); id != nil {
+			next.ServeHTTP(w, withIdentity(r, id))
 			return
 		}
 
@@ -40,15 +109,57 @@ func (a *Authenticator) Authenticate(next http.Handler) http.Handler {
 			return
 		}
 
-		if _, ok := a.tokens[token]; !ok {
+		if !tokens.verify(r.Context(), token) {
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
 
-		next.ServeHTTP(w, r)
+		next.ServeHTTP(w, withIdentity(r, &Identity{Name: tokenName(token), Permissions: allPermissions}))
 	})
 }
 
+// Require rejects requests whose identity lacks perm.
+func Require(perm string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := IdentityFromContext(r.Context())
+		if id == nil || !id.Has(perm) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func withIdentity(r *http.Request, id *Identity) *http.Request {
+	logging.AddFields(r.Context(), "identity", id.Name)
+	return r.WithContext(context.WithValue(r.Context(), identityKey{}, id))
+}
+
+// certIdentity maps a verified client certificate to its configured
+// permissions, matching the common name first and then the full subject.
+func certIdentity(r *http.Request, clientCerts map[string][]string) *Identity {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	leaf := r.TLS.VerifiedChains[0][0]
+	for _, subject := range certSubjects(leaf) {
+		if perms, ok := clientCerts[subject]; ok {
+			return &Identity{Name: "cert:" + subject, Permissions: perms}
+		}
+	}
+	return nil
+}
+
+func certSubjects(cert *x509.Certificate) []string {
+	return []string{cert.Subject.CommonName, cert.Subject.String()}
+}
+
+// tokenName identifies a token without revealing it.
+func tokenName(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return "token:" + hex.EncodeToString(sum[:4])
+}
+
 func extractToken(auth string) string {
 	// handle "Authorization: your-token" format
 	if !strings.Contains(auth, " ") {
@@ -1,26 +1,59 @@
 package api
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
-	"os"
+	"strconv"
 
 	"github.com/ipfs/go-cidutil/cidenc"
 	"github.com/multiformats/go-multibase"
+	"github.com/web3tea/piecehub/internal/audit"
 	"github.com/web3tea/piecehub/internal/car"
+	"github.com/web3tea/piecehub/internal/jobs"
+	"github.com/web3tea/piecehub/internal/logging"
+	"github.com/web3tea/piecehub/internal/meta"
+	"github.com/web3tea/piecehub/storage"
 )
 
+// jobGenerateCar is the job type of CAR generation.
+const jobGenerateCar = "generate-car"
+
+type generateRequest struct {
+	Size        int64   `json:"size"`
+	StorageName string  `json:"storageName"`
+	Seed        *uint64 `json:"seed"`
+	Mode        string  `json:"mode"`
+	BlockSize   int64   `json:"blockSize"`
+	Fanout      int     `json:"fanout"`
+	Files       int     `json:"files"`
+	Async       bool    `json:"async"`
+}
+
+type generateResult struct {
+	PieceCID    string `json:"pieceCid"`
+	PieceSize   uint64 `json:"pieceSize"`
+	PayloadSize uint64 `json:"payloadSize"`
+	CarSize     uint64 `json:"carSize"`
+	CarCID      string `json:"carCid"`
+	Seed        uint64 `json:"seed"`
+}
+
+// handleGenerateCar generates a test CAR into a storage as a job. With
+// async the job is returned right away, otherwise the request waits for
+// the result.
 func (h *Handler) handleGenerateCar(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	var req struct {
-		Size        int64  `json:"size"`
-		StorageName string `json:"storageName"`
-	}
-
+	var req generateRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
@@ -32,63 +65,151 @@ func (h *Handler) handleGenerateCar(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	auditEvent(r.Context()).Storage = req.StorageName
 	st, err := h.store.GetStorage(req.StorageName)
 	if err != nil {
 		http.Error(w, "Storage not found", http.StatusNotFound)
 		return
 	}
 
-	carPath, cid, err := car.GenerateCar(req.Size, 1<<20)
-	if err != nil {
-		http.Error(w, "Failed to generate car", http.StatusInternalServerError)
+	opts := car.GenerateOptions{
+		Size:      req.Size,
+		Seed:      car.RandomSeed(),
+		Mode:      req.Mode,
+		BlockSize: req.BlockSize,
+		Fanout:    req.Fanout,
+		Files:     req.Files,
+	}
+	if req.Seed != nil {
+		opts.Seed = *req.Seed
+	}
+	if err := opts.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	req.Seed = &opts.Seed
+
+	run := func(ctx context.Context, p *jobs.Progress) (any, error) {
+		release, err := h.admission.Acquire(ctx, st.Name())
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+		e := audit.NewEvent(ctx, "piece.generate")
+		e.Storage = st.Name()
+		res, err := generatePiece(ctx, st, opts, p)
+		if err != nil {
+			e.Outcome, e.Error = audit.Failure, err.Error()
+			h.audit.Log(e)
+			return nil, err
+		}
+		e.Outcome, e.Piece, e.Size = audit.Success, res.PieceCID, int64(res.CarSize)
+		h.audit.Log(e)
+
+		_, err = h.meta.Put(res.PieceCID, meta.Meta{
+			PayloadCID:  res.CarCID,
+			PayloadSize: int64(res.CarSize),
+			Labels: map[string]string{
+				"source": jobGenerateCar,
+				"seed":   strconv.FormatUint(opts.Seed, 10),
+			},
+		})
+		if err != nil {
+			logging.FromContext(ctx).Error("store generated piece metadata", "piece", res.PieceCID, "err", err)
+		}
+		return res, nil
+	}
 
-	defer os.Remove(carPath)
-
-	fd, err := os.Open(carPath)
+	// async jobs outlive the request but keep its logger and trace
+	ctx := r.Context()
+	if req.Async {
+		ctx = context.WithoutCancel(ctx)
+	}
+	job, err := h.jobs.Submit(ctx, jobGenerateCar, &req, run)
 	if err != nil {
-		http.Error(w, "Failed to open car file", http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
 		return
 	}
-	defer fd.Close()
-
-	ci, err := fd.Stat()
-	if err != nil {
-		http.Error(w, "Failed to stat car file", http.StatusInternalServerError)
+	logging.AddFields(r.Context(), "job", job.ID)
+	auditEvent(r.Context()).Job = job.ID
+
+	if req.Async {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Location", "/jobs/"+job.ID)
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(&job)
 		return
 	}
 
-	// commp
-	cp, err := car.Commp(carPath)
-	if err != nil {
-		http.Error(w, "Failed to generate commP", http.StatusInternalServerError)
+	job, err = h.jobs.Wait(r.Context(), job.ID)
+	switch {
+	case errors.Is(err, errQueueFull) || errors.Is(err, errQueueTimeout):
+		w.Header().Set("Retry-After", admissionRetryAfter)
+		http.Error(w, "Service Unavailable - "+err.Error(), http.StatusServiceUnavailable)
+		return
+	case err != nil:
+		logging.FromContext(r.Context()).Error("generate car", "err", err)
+		http.Error(w, "Failed to generate car", http.StatusInternalServerError)
 		return
 	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job.Result)
+}
 
-	encoder := cidenc.Encoder{Base: multibase.MustNewEncoder(multibase.Base32)}
-
-	pieceCid := encoder.Encode(cp.PieceCID)
-	err = st.Write(r.Context(), pieceCid, fd)
+// generatePiece streams the CAR once into st under a hidden name while
+// its commP is computed, then renames it to its piece CID.
+func generatePiece(ctx context.Context, st storage.Storage, opts car.GenerateOptions, p *jobs.Progress) (*generateResult, error) {
+	plan, err := car.NewPlan(ctx, opts)
 	if err != nil {
-		http.Error(w, "Failed to write car to storage", http.StatusInternalServerError)
-		return
+		return nil, err
+	}
+	p.SetTotal(plan.Size)
+
+	var b [8]byte
+	rand.Read(b[:])
+	tmp := ".generate-" + hex.EncodeToString(b[:])
+
+	cw := car.NewParallelWriter(0)
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := plan.WriteTo(ctx, io.MultiWriter(pw, cw, p))
+		pw.CloseWithError(err)
+		done <- err
+	}()
+
+	err = st.Write(ctx, tmp, pr, plan.Size)
+	// unblock the generator if the storage stopped reading early
+	pr.CloseWithError(io.ErrClosedPipe)
+	if gerr := <-done; err == nil {
+		err = gerr
+	}
+	if err != nil {
+		deleteTemp(ctx, st, tmp)
+		return nil, fmt.Errorf("write car: %w", err)
 	}
 
-	type response struct {
-		PieceCID    string `json:"pieceCid"`
-		PieceSize   uint64 `json:"pieceSize"`
-		PayloadSize uint64 `json:"payloadSize"`
-		CarSize     uint64 `json:"carSize"`
-		CarCID      string `json:"carCid"`
+	cp, err := cw.Sum()
+	if err == nil && int64(cp.PayloadSize) != plan.Size {
+		err = fmt.Errorf("generated %d bytes, planned %d", cp.PayloadSize, plan.Size)
+	}
+	if err != nil {
+		deleteTemp(ctx, st, tmp)
+		return nil, fmt.Errorf("compute commP: %w", err)
+	}
+	encoder := cidenc.Encoder{Base: multibase.MustNewEncoder(multibase.Base32)}
+	pieceCid := encoder.Encode(cp.PieceCID)
+	if err := st.Rename(ctx, tmp, pieceCid); err != nil {
+		deleteTemp(ctx, st, tmp)
+		return nil, fmt.Errorf("rename car: %w", err)
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(&response{
+	return &generateResult{
 		PieceCID:    pieceCid,
 		PieceSize:   uint64(cp.PieceSize),
 		PayloadSize: uint64(cp.PayloadSize),
-		CarCID:      cid.String(),
-		CarSize:     uint64(ci.Size()),
-	})
+		CarCID:      plan.Root.String(),
+		CarSize:     uint64(plan.Size),
+		Seed:        opts.Seed,
+	}, nil
 }
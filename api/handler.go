@@ -2,35 +2,126 @@ package api
 
 import (
 	"encoding/json"
+	"expvar"
 	"net/http"
 	"strconv"
+	"sync"
 
 	"github.com/web3tea/piecehub/config"
+	"github.com/web3tea/piecehub/internal/audit"
+	"github.com/web3tea/piecehub/internal/jobs"
+	"github.com/web3tea/piecehub/internal/logging"
+	"github.com/web3tea/piecehub/internal/meta"
+	"github.com/web3tea/piecehub/internal/retention"
+	"github.com/web3tea/piecehub/internal/tracing"
 	"github.com/web3tea/piecehub/storage"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// ConfigLoader returns a freshly loaded configuration, used on reload.
+type ConfigLoader func() (*config.Config, error)
+
 type Handler struct {
-	store storage.Manager
-	cfg   *config.Config
+	store     storage.Manager
+	cfg       *config.Config
+	auth      *Authenticator
+	limiter   *RateLimiter
+	admission *Admission
+	jobs      *jobs.Manager
+	meta      *meta.Store
+	reaper    *retention.Reaper
+	audit     *audit.Logger
+	loader    ConfigLoader
+	handler   http.Handler
+	mu        sync.Mutex
+	denials   authDenials
+	// uploads holds the pieces being uploaded, to reject concurrent
+	// uploads of the same piece
+	uploads sync.Map
 }
 
-func NewHandler(cfg *config.Config, store storage.Manager) http.Handler {
+// NewHandler builds the HTTP API. loader may be nil, in which case
+// configuration reloads are rejected.
+func NewHandler(cfg *config.Config, store storage.Manager, jobMgr *jobs.Manager, metaStore *meta.Store, reaper *retention.Reaper, auditLog *audit.Logger, loader ConfigLoader) *Handler {
 	mux := http.NewServeMux()
-	h := &Handler{store: store, cfg: cfg}
-
-	mux.HandleFunc("/pieces", h.handlePieces)
-	mux.HandleFunc("/storages", h.handleStorageList)
+	h := &Handler{store: store, cfg: cfg, jobs: jobMgr, meta: metaStore, reaper: reaper, audit: auditLog, loader: loader}
+
+	mux.HandleFunc("/pieces", methods{
+		http.MethodGet:    Require(PermRead, h.handlePieces),
+		http.MethodHead:   Require(PermRead, h.handlePieces),
+		http.MethodPut:    h.audited("piece.upload", Require(PermWrite, h.handleUpload)),
+		http.MethodDelete: h.audited("piece.delete", Require(PermWrite, h.handleDelete)),
+	}.ServeHTTP)
+	mux.HandleFunc("/pieces/list", Require(PermRead, h.handlePieceList))
+	mux.HandleFunc("/pieces/stat", Require(PermRead, h.handlePieceStat))
+	mux.HandleFunc("/pieces/{cid}/meta", methods{
+		http.MethodGet: Require(PermRead, h.handleGetMeta),
+		http.MethodPut: h.audited("piece.meta", Require(PermWrite, h.handlePutMeta)),
+	}.ServeHTTP)
+	mux.HandleFunc("/storages", Require(PermRead, h.handleStorageList))
+	mux.HandleFunc("/status", Require(PermRead, h.handleStatus))
+
+	// admin
+	mux.HandleFunc("/admin/reload", h.audited("admin.reload", Require(PermAdmin, h.handleReload)))
+	mux.HandleFunc("/admin/retention", h.audited("admin.retention", Require(PermAdmin, h.handleRetention)))
+	mux.HandleFunc("/jobs", h.audited("job.list", Require(PermAdmin, h.handleJobList)))
+	mux.HandleFunc("/jobs/{id}", methods{
+		// polled by clients waiting for jobs, so not audited
+		http.MethodGet:    Require(PermAdmin, h.handleJob),
+		http.MethodDelete: h.audited("job.cancel", Require(PermAdmin, h.handleJobCancel)),
+	}.ServeHTTP)
 
 	// debug
-	mux.HandleFunc("/debug/generate-car", h.handleGenerateCar)
+	mux.HandleFunc("/debug/generate-car", h.audited("debug.generate-car", Require(PermAdmin, h.handleGenerateCar)))
+	mux.HandleFunc("/debug/vars", h.audited("debug.vars", Require(PermAdmin, expvar.Handler().ServeHTTP)))
 
-	handler := logMiddleware(mux)
+	h.admission = NewAdmission(&cfg.Limits)
+	h.admission.publish()
+
+	// per-token limits need the identity, so they run after auth; global
+	// and per-IP limits run before it, so that guessing tokens is limited
+	h.limiter = NewRateLimiter(&cfg.Limits)
+	handler := h.limiter.LimitIdentity(mux)
 
 	// auth
-	authenticator := NewAuthenticator(cfg.Server.Tokens)
-	handler = authenticator.Authenticate(handler)
+	h.auth = NewAuthenticator(&cfg.Server)
+	handler = h.auth.Authenticate(handler)
+	handler = h.limiter.LimitClient(handler)
+	handler = h.auditUnauthorized(handler)
+
+	handler = logMiddleware(handler)
+	handler = traceMiddleware(handler)
+
+	// probes bypass auth, limits and access logs
+	root := http.NewServeMux()
+	root.HandleFunc("/healthz", h.handleHealthz)
+	root.HandleFunc("/readyz", h.handleReadyz)
+	root.Handle("/", handler)
+	h.handler = root
+
+	return h
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.handler.ServeHTTP(w, r)
+}
+
+// Close audits the denied requests counted but not logged yet. It is
+// called on shutdown, before the audit log is closed.
+func (h *Handler) Close() {
+	h.denials.flush(h.reportDenials)
+}
 
-	return handler
+// methods routes a request by its method.
+type methods map[string]http.HandlerFunc
+
+func (m methods) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fn, ok := m[r.Method]
+	if !ok {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	fn(w, r)
 }
 
 func (h *Handler) handlePieces(w http.ResponseWriter, r *http.Request) {
@@ -45,8 +136,10 @@ func (h *Handler) handlePieces(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "piece id required", http.StatusBadRequest)
 		return
 	}
+	logging.AddFields(r.Context(), "piece", pieceCid)
+	trace.SpanFromContext(r.Context()).SetAttributes(tracing.AttrPieceCID.String(pieceCid))
 
-	size, err := h.store.Stats(r.Context(), pieceCid)
+	st, size, err := h.store.Locate(r.Context(), pieceCid)
 	if err != nil {
 		http.Error(w, "file not found", http.StatusNotFound)
 		return
@@ -59,8 +152,17 @@ func (h *Handler) handlePieces(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	release, ok := h.admit(w, r, st.Name())
+	if !ok {
+		return
+	}
+	defer release()
+
 	w.Header().Set("Content-Type", "application/octet-stream")
-	h.store.CopyToHTTP(r.Context(), pieceCid, w, r)
+	w = h.limiter.ThrottleStorage(w, r, st.Name())
+	if err := st.CopyToHTTP(r.Context(), pieceCid, w, r); err != nil {
+		logging.FromContext(r.Context()).Error("copy piece", "piece", pieceCid, "err", err)
+	}
 }
 
 func (h *Handler) handleStorageList(w http.ResponseWriter, r *http.Request) {
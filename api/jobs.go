@@ -0,0 +1,61 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/web3tea/piecehub/internal/jobs"
+	"github.com/web3tea/piecehub/internal/logging"
+)
+
+// handleJobList lists jobs, optionally filtered by the type and state
+// parameters.
+func (h *Handler) handleJobList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	state := jobs.State(r.URL.Query().Get("state"))
+	list := []jobs.Job{}
+	for _, j := range h.jobs.List(r.URL.Query().Get("type")) {
+		if state == "" || j.State == state {
+			list = append(list, j)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+func (h *Handler) handleJob(w http.ResponseWriter, r *http.Request) {
+	auditEvent(r.Context()).Job = r.PathValue("id")
+	j, ok := h.jobs.Get(r.PathValue("id"))
+	if !ok {
+		http.Error(w, "job not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&j)
+}
+
+// handleJobCancel cancels a job. Running jobs report canceled once they
+// have stopped.
+func (h *Handler) handleJobCancel(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	logging.AddFields(r.Context(), "job", id)
+	auditEvent(r.Context()).Job = id
+
+	j, err := h.jobs.Cancel(id)
+	switch {
+	case errors.Is(err, jobs.ErrNotFound):
+		http.Error(w, "job not found", http.StatusNotFound)
+		return
+	case errors.Is(err, jobs.ErrFinished):
+		http.Error(w, "job already finished", http.StatusConflict)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&j)
+}
@@ -1,34 +1,55 @@
 package api
 
 import (
-	"log"
 	"net/http"
 	"time"
+
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/web3tea/piecehub/internal/logging"
 )
 
+const requestIDHeader = "X-Request-Id"
+
 func logMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		startTime := time.Now()
 
+		reqID := r.Header.Get(requestIDHeader)
+		if reqID == "" || len(reqID) > 128 {
+			reqID = logging.NewRequestID()
+		}
+		w.Header().Set(requestIDHeader, reqID)
+
+		ctx := logging.WithRequestID(r.Context(), reqID)
+		ctx, fields := logging.WithFields(ctx)
+
 		lw := &logWriter{ResponseWriter: w}
 
-		next.ServeHTTP(lw, r)
+		next.ServeHTTP(lw, r.WithContext(ctx))
 
-		duration := time.Since(startTime)
+		args := []any{
+			"method", r.Method,
+			"uri", r.RequestURI,
+			"status", lw.status(),
+			"bytes", lw.bytes,
+			"duration", time.Since(startTime),
+			"remote", r.RemoteAddr,
+			"user_agent", r.UserAgent(),
+		}
+		if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+			args = append(args, "trace_id", sc.TraceID().String())
+		}
+		args = append(args, fields.Args()...)
 
-		log.Printf("%s %s %d %s %s",
-			r.Method,
-			r.RequestURI,
-			lw.statusCode,
-			r.RemoteAddr,
-			duration,
-		)
+		logging.FromContext(ctx).Info("access", args...)
 	})
 }
 
 type logWriter struct {
 	http.ResponseWriter
 	statusCode int
+	bytes      int64
 }
 
 func (w *logWriter) WriteHeader(code int) {
@@ -40,5 +61,20 @@ func (w *logWriter) Write(b []byte) (int, error) {
 	if w.statusCode == 0 {
 		w.statusCode = http.StatusOK
 	}
-	return w.ResponseWriter.Write(b)
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// status returns the response code, defaulting to 200 when the handler
+// wrote nothing.
+func (w *logWriter) status() int {
+	if w.statusCode == 0 {
+		return http.StatusOK
+	}
+	return w.statusCode
+}
+
+func (w *logWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
 }
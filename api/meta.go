@@ -0,0 +1,113 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/web3tea/piecehub/internal/logging"
+	"github.com/web3tea/piecehub/internal/meta"
+)
+
+// maxMetaBody bounds the metadata document of a piece.
+const maxMetaBody = 64 << 10
+
+// pieceCidParam returns the normalized piece cid of the path.
+func pieceCidParam(r *http.Request) (string, error) {
+	pc, err := cid.Decode(r.PathValue("cid"))
+	if err != nil {
+		return "", fmt.Errorf("invalid piece cid: %w", err)
+	}
+	pieceCid := pc.String()
+	logging.AddFields(r.Context(), "piece", pieceCid)
+	return pieceCid, nil
+}
+
+func (h *Handler) handleGetMeta(w http.ResponseWriter, r *http.Request) {
+	pieceCid, err := pieceCidParam(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	m, ok := h.meta.Get(pieceCid)
+	if !ok {
+		http.Error(w, "metadata not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&m)
+}
+
+// handlePutMeta replaces the metadata of a stored piece.
+func (h *Handler) handlePutMeta(w http.ResponseWriter, r *http.Request) {
+	pieceCid, err := pieceCidParam(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var m meta.Meta
+	err = json.NewDecoder(http.MaxBytesReader(w, r.Body, maxMetaBody)).Decode(&m)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, fmt.Sprintf("metadata too large, at most %d bytes", maxMetaBody), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(w, "invalid metadata: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, _, err := h.store.Locate(r.Context(), pieceCid); err != nil {
+		http.Error(w, "piece not found", http.StatusNotFound)
+		return
+	}
+
+	m, err = h.meta.Put(pieceCid, m)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("put metadata", "piece", pieceCid, "err", err)
+		http.Error(w, "failed to store metadata", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&m)
+}
+
+// metaFilter parses the metadata filters of a piece listing: label=key=value
+// (repeatable), client, deal, payloadCid and expiresBefore (RFC 3339).
+func metaFilter(r *http.Request) (*meta.Filter, error) {
+	q := r.URL.Query()
+	f := &meta.Filter{
+		Client:     q.Get("client"),
+		PayloadCID: q.Get("payloadCid"),
+	}
+	for _, label := range q["label"] {
+		k, v, ok := strings.Cut(label, "=")
+		if !ok || k == "" {
+			return nil, fmt.Errorf("invalid label %q, expected key=value", label)
+		}
+		if f.Labels == nil {
+			f.Labels = make(map[string]string)
+		}
+		f.Labels[k] = v
+	}
+	if deal := q.Get("deal"); deal != "" {
+		id, err := strconv.ParseUint(deal, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid deal %q", deal)
+		}
+		f.DealID = id
+	}
+	if before := q.Get("expiresBefore"); before != "" {
+		t, err := time.Parse(time.RFC3339, before)
+		if err != nil {
+			return nil, fmt.Errorf("invalid expiresBefore %q", before)
+		}
+		f.ExpiresBefore = t
+	}
+	return f, nil
+}
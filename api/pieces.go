@@ -0,0 +1,276 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+	"github.com/web3tea/piecehub/internal/car"
+	"github.com/web3tea/piecehub/internal/logging"
+	"github.com/web3tea/piecehub/internal/meta"
+	"github.com/web3tea/piecehub/internal/tracing"
+	"github.com/web3tea/piecehub/storage"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// uploadCommpWorkers caps the goroutines, and 16 MiB buffers, hashing the
+// commP of each upload. Uploads are bound by the network long before.
+const uploadCommpWorkers = 4
+
+type pieceInfo struct {
+	PieceCID string     `json:"pieceCid"`
+	Size     int64      `json:"size"`
+	Storage  string     `json:"storage"`
+	Meta     *meta.Meta `json:"meta,omitempty"`
+}
+
+// handleUpload stores the request body as a piece. The body is written
+// under a hidden name while its commP is computed and only renamed to id
+// once the commP matches.
+func (h *Handler) handleUpload(w http.ResponseWriter, r *http.Request) {
+	pc, err := cid.Decode(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "invalid piece id", http.StatusBadRequest)
+		return
+	}
+	pieceCid := pc.String()
+	logging.AddFields(r.Context(), "piece", pieceCid)
+	trace.SpanFromContext(r.Context()).SetAttributes(tracing.AttrPieceCID.String(pieceCid))
+	ae := auditEvent(r.Context())
+	ae.Piece = pieceCid
+
+	st, err := h.storageParam(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ae.Storage = st.Name()
+	if _, busy := h.uploads.LoadOrStore(pieceCid, struct{}{}); busy {
+		http.Error(w, "piece upload in progress", http.StatusConflict)
+		return
+	}
+	defer h.uploads.Delete(pieceCid)
+	if _, _, err := h.store.Locate(r.Context(), pieceCid); err == nil {
+		http.Error(w, "piece already exists", http.StatusConflict)
+		return
+	}
+
+	release, ok := h.admit(w, r, st.Name())
+	if !ok {
+		return
+	}
+	defer release()
+
+	// every attempt uses the same name, so that storages can resume an
+	// interrupted upload; uploads keeps attempts from running at once
+	tmp := ".upload-" + pieceCid
+	cw := car.NewParallelWriter(uploadCommpWorkers)
+	body := io.TeeReader(r.Body, cw)
+	if err := st.Write(r.Context(), tmp, body, r.ContentLength); err != nil {
+		logging.FromContext(r.Context()).Error("write piece", "piece", pieceCid, "err", err)
+		deleteTemp(r.Context(), st, tmp)
+		http.Error(w, "failed to write piece", http.StatusInternalServerError)
+		return
+	}
+
+	sum, err := cw.Sum()
+	if err == nil && !sum.PieceCID.Equals(pc) {
+		err = fmt.Errorf("commP mismatch: data has %s", sum.PieceCID)
+	}
+	if err != nil {
+		deleteTemp(r.Context(), st, tmp)
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if err := st.Rename(r.Context(), tmp, pieceCid); err != nil {
+		logging.FromContext(r.Context()).Error("rename piece", "piece", pieceCid, "err", err)
+		deleteTemp(r.Context(), st, tmp)
+		http.Error(w, "failed to write piece", http.StatusInternalServerError)
+		return
+	}
+
+	ae.Size = int64(sum.PayloadSize)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(&pieceInfo{
+		PieceCID: pieceCid,
+		Size:     int64(sum.PayloadSize),
+		Storage:  st.Name(),
+	})
+}
+
+// deleteTemp removes a piece written under a temporary name, also when
+// ctx was cancelled by the client going away.
+func deleteTemp(ctx context.Context, st storage.Storage, name string) {
+	if err := st.Delete(context.WithoutCancel(ctx), name); err != nil {
+		logging.FromContext(ctx).Error("delete temporary piece", "name", name, "err", err)
+	}
+}
+
+// storageParam returns the storage named by the storage query parameter,
+// which may be omitted when only one storage is configured.
+func (h *Handler) storageParam(r *http.Request) (storage.Storage, error) {
+	name := r.URL.Query().Get("storage")
+	if name == "" {
+		names := h.store.ListStorages()
+		if len(names) != 1 {
+			return nil, errors.New("storage required")
+		}
+		name = names[0]
+	}
+	logging.AddFields(r.Context(), "storage", name)
+	return h.store.GetStorage(name)
+}
+
+func (h *Handler) handleDelete(w http.ResponseWriter, r *http.Request) {
+	pc, err := cid.Decode(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "invalid piece id", http.StatusBadRequest)
+		return
+	}
+	pieceCid := pc.String()
+	logging.AddFields(r.Context(), "piece", pieceCid)
+	trace.SpanFromContext(r.Context()).SetAttributes(tracing.AttrPieceCID.String(pieceCid))
+	ae := auditEvent(r.Context())
+	ae.Piece = pieceCid
+
+	st, size, err := h.store.Locate(r.Context(), pieceCid)
+	if err != nil {
+		http.Error(w, "file not found", http.StatusNotFound)
+		return
+	}
+	ae.Storage, ae.Size = st.Name(), size
+	if err := h.store.Delete(r.Context(), pieceCid); err != nil {
+		logging.FromContext(r.Context()).Error("delete piece", "piece", pieceCid, "err", err)
+		http.Error(w, "failed to delete piece", http.StatusInternalServerError)
+		return
+	}
+	if err := h.meta.Delete(pieceCid); err != nil {
+		logging.FromContext(r.Context()).Error("delete metadata", "piece", pieceCid, "err", err)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlePieceList lists the pieces of one storage, or of all storages when
+// the storage parameter is omitted, with their metadata. Metadata filters
+// only return pieces with matching metadata.
+func (h *Handler) handlePieceList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	filter, err := metaFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	metas := h.meta.Find(filter)
+
+	names := h.store.ListStorages()
+	if name := r.URL.Query().Get("storage"); name != "" {
+		names = []string{name}
+	}
+
+	pieces := []pieceInfo{}
+	for _, name := range names {
+		st, err := h.store.GetStorage(name)
+		if err != nil {
+			http.Error(w, "storage not found", http.StatusNotFound)
+			return
+		}
+		err = st.List(r.Context(), func(piece string, size int64) error {
+			info := pieceInfo{PieceCID: piece, Size: size, Storage: name}
+			if m, ok := metas[piece]; ok {
+				info.Meta = &m
+			} else if !filter.Empty() {
+				return nil
+			}
+			pieces = append(pieces, info)
+			return nil
+		})
+		if err != nil {
+			logging.FromContext(r.Context()).Error("list pieces", "storage", name, "err", err)
+			http.Error(w, "failed to list pieces", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pieces)
+}
+
+const (
+	// maxStatBatch bounds the pieces of one batch stat request.
+	maxStatBatch = 10000
+	// maxStatBody bounds its body, allowing 128 bytes per piece cid.
+	maxStatBody = maxStatBatch * 128
+	// statConcurrency bounds the lookups of a batch stat request in flight.
+	statConcurrency = 32
+)
+
+type pieceStat struct {
+	PieceCID string `json:"pieceCid"`
+	Found    bool   `json:"found"`
+	Size     int64  `json:"size,omitempty"`
+	Storage  string `json:"storage,omitempty"`
+}
+
+// handlePieceStat looks up a JSON array of piece cids and reports, in the
+// same order, whether and where each piece is stored. Lookups go through
+// the location cache and at most statConcurrency run at once.
+func (h *Handler) handlePieceStat(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var pieces []string
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxStatBody)).Decode(&pieces)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) || len(pieces) > maxStatBatch {
+		http.Error(w, fmt.Sprintf("too many pieces, at most %d per request", maxStatBatch), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(w, "invalid request body, expected an array of piece cids", http.StatusBadRequest)
+		return
+	}
+	logging.AddFields(r.Context(), "pieces", len(pieces))
+
+	ctx := logging.WithoutFields(r.Context())
+	stats := make([]pieceStat, len(pieces))
+	sem := make(chan struct{}, statConcurrency)
+	var wg sync.WaitGroup
+	for i, piece := range pieces {
+		stats[i].PieceCID = piece
+		if piece == "" {
+			continue
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			st, size, err := h.store.Locate(ctx, piece)
+			if err != nil {
+				return
+			}
+			stats[i] = pieceStat{PieceCID: piece, Found: true, Size: size, Storage: st.Name()}
+		}()
+	}
+	wg.Wait()
+
+	if err := r.Context().Err(); err != nil {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
@@ -0,0 +1,231 @@
+package api
+
+import (
+	"context"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/golang-lru/v2/expirable"
+	"github.com/web3tea/piecehub/config"
+	"golang.org/x/time/rate"
+)
+
+const (
+	limiterCacheSize = 64 * 1024
+	limiterIdleTTL   = 10 * time.Minute
+)
+
+// limiterSet holds the request and byte limiters of one scope, keyed by
+// token, client IP or storage name.
+type limiterSet struct {
+	cfg      config.RateLimit
+	requests *expirable.LRU[string, *rate.Limiter]
+	bytes    *expirable.LRU[string, *rate.Limiter]
+}
+
+func newLimiterSet(cfg config.RateLimit) *limiterSet {
+	return &limiterSet{
+		cfg:      cfg,
+		requests: expirable.NewLRU[string, *rate.Limiter](limiterCacheSize, nil, limiterIdleTTL),
+		bytes:    expirable.NewLRU[string, *rate.Limiter](limiterCacheSize, nil, limiterIdleTTL),
+	}
+}
+
+func (s *limiterSet) request(key string) *rate.Limiter {
+	if s.cfg.RequestsPerSecond <= 0 {
+		return nil
+	}
+	if l, ok := s.requests.Get(key); ok {
+		return l
+	}
+	burst := s.cfg.Burst
+	if burst <= 0 {
+		burst = int(math.Ceil(s.cfg.RequestsPerSecond))
+	}
+	l := rate.NewLimiter(rate.Limit(s.cfg.RequestsPerSecond), burst)
+	s.requests.Add(key, l)
+	return l
+}
+
+func (s *limiterSet) egress(key string) *rate.Limiter {
+	if s.cfg.BytesPerSecond <= 0 {
+		return nil
+	}
+	if l, ok := s.bytes.Get(key); ok {
+		return l
+	}
+	// one second worth of burst
+	l := rate.NewLimiter(rate.Limit(s.cfg.BytesPerSecond), int(s.cfg.BytesPerSecond))
+	s.bytes.Add(key, l)
+	return l
+}
+
+// RateLimiter enforces config.LimitsConfig in the HTTP layer.
+type RateLimiter struct {
+	mu         sync.RWMutex
+	global     *limiterSet
+	perToken   *limiterSet
+	perIP      *limiterSet
+	perStorage *limiterSet
+}
+
+func NewRateLimiter(cfg *config.LimitsConfig) *RateLimiter {
+	rl := &RateLimiter{}
+	rl.Update(cfg)
+	return rl
+}
+
+// Update applies new limits. Scopes whose limits are unchanged keep their
+// buckets; changed ones start over.
+func (rl *RateLimiter) Update(cfg *config.LimitsConfig) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.global = keepLimiterSet(rl.global, cfg.Global)
+	rl.perToken = keepLimiterSet(rl.perToken, cfg.PerToken)
+	rl.perIP = keepLimiterSet(rl.perIP, cfg.PerIP)
+	rl.perStorage = keepLimiterSet(rl.perStorage, cfg.PerStorage)
+}
+
+func keepLimiterSet(s *limiterSet, cfg config.RateLimit) *limiterSet {
+	if s != nil && s.cfg == cfg {
+		return s
+	}
+	return newLimiterSet(cfg)
+}
+
+// LimitClient applies the global and per-IP limits. It runs before
+// authentication, so that failed token checks count against the client.
+func (rl *RateLimiter) LimitClient(next http.Handler) http.Handler {
+	return rl.limit(next, func(r *http.Request) []scopeKey {
+		return []scopeKey{{set: rl.global, key: ""}, {set: rl.perIP, key: clientIP(r)}}
+	})
+}
+
+// LimitIdentity applies the per-token limits once the caller is known.
+func (rl *RateLimiter) LimitIdentity(next http.Handler) http.Handler {
+	return rl.limit(next, func(r *http.Request) []scopeKey {
+		if id := IdentityFromContext(r.Context()); id != nil && id.Name != anonymous {
+			return []scopeKey{{set: rl.perToken, key: id.Name}}
+		}
+		return nil
+	})
+}
+
+// limit rejects requests over the request rate of the scopes with 429 and
+// throttles the response body to their egress caps.
+func (rl *RateLimiter) limit(next http.Handler, scopesOf func(r *http.Request) []scopeKey) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rl.mu.RLock()
+		scopes := scopesOf(r)
+		rl.mu.RUnlock()
+
+		var reqLimiters, byteLimiters []*rate.Limiter
+		for _, sc := range scopes {
+			if l := sc.set.request(sc.key); l != nil {
+				reqLimiters = append(reqLimiters, l)
+			}
+			if l := sc.set.egress(sc.key); l != nil {
+				byteLimiters = append(byteLimiters, l)
+			}
+		}
+
+		if delay := reserve(reqLimiters); delay > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+			return
+		}
+
+		next.ServeHTTP(throttle(w, r.Context(), byteLimiters), r)
+	})
+}
+
+// ThrottleStorage caps the egress of w to the per-storage limit of storage.
+func (rl *RateLimiter) ThrottleStorage(w http.ResponseWriter, r *http.Request, storage string) http.ResponseWriter {
+	rl.mu.RLock()
+	set := rl.perStorage
+	rl.mu.RUnlock()
+
+	if l := set.egress(storage); l != nil {
+		return throttle(w, r.Context(), []*rate.Limiter{l})
+	}
+	return w
+}
+
+type scopeKey struct {
+	set *limiterSet
+	key string
+}
+
+// reserve takes a token from every limiter, or none of them if any would
+// have to wait, in which case the longest wait is returned.
+func reserve(limiters []*rate.Limiter) time.Duration {
+	now := time.Now()
+	reservations := make([]*rate.Reservation, 0, len(limiters))
+	var delay time.Duration
+	for _, l := range limiters {
+		res := l.ReserveN(now, 1)
+		reservations = append(reservations, res)
+		if !res.OK() {
+			delay = max(delay, time.Second)
+			continue
+		}
+		delay = max(delay, res.DelayFrom(now))
+	}
+	if delay > 0 {
+		for _, res := range reservations {
+			res.CancelAt(now)
+		}
+	}
+	return delay
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// throttledWriter blocks writes until every limiter admits the bytes.
+type throttledWriter struct {
+	http.ResponseWriter
+	ctx      context.Context
+	limiters []*rate.Limiter
+}
+
+func throttle(w http.ResponseWriter, ctx context.Context, limiters []*rate.Limiter) http.ResponseWriter {
+	if len(limiters) == 0 {
+		return w
+	}
+	return &throttledWriter{ResponseWriter: w, ctx: ctx, limiters: limiters}
+}
+
+func (w *throttledWriter) Write(b []byte) (int, error) {
+	written := 0
+	for written < len(b) {
+		chunk := len(b) - written
+		for _, l := range w.limiters {
+			chunk = min(chunk, l.Burst())
+		}
+		for _, l := range w.limiters {
+			if err := l.WaitN(w.ctx, chunk); err != nil {
+				return written, err
+			}
+		}
+		n, err := w.ResponseWriter.Write(b[written : written+chunk])
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+func (w *throttledWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,91 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"time"
+
+	"github.com/hashicorp/golang-lru/v2/expirable"
+	"github.com/web3tea/piecehub/internal/tokenhash"
+)
+
+const (
+	argonCacheSize = 1024
+	argonCacheTTL  = 5 * time.Minute
+	// argonConcurrency caps the argon2id checks running at once, each of
+	// which takes 64 MiB with the default parameters.
+	argonConcurrency = 4
+)
+
+// argonSlots is shared by all token sets so that reloads do not raise the
+// cap.
+var argonSlots = make(chan struct{}, argonConcurrency)
+
+// tokenSet verifies presented tokens against plaintext, sha256 and argon2id
+// entries. argon2id is deliberately slow, so accepted tokens are cached by
+// their digest. Rejections are not cached, to keep unknown tokens from
+// evicting valid ones.
+type tokenSet struct {
+	plain   map[string]struct{}
+	sha256  map[string]struct{}
+	argon2  []string
+	results *expirable.LRU[[sha256.Size]byte, struct{}]
+}
+
+func newTokenSet(entries []string) *tokenSet {
+	ts := &tokenSet{
+		plain:   make(map[string]struct{}),
+		sha256:  make(map[string]struct{}),
+		results: expirable.NewLRU[[sha256.Size]byte, struct{}](argonCacheSize, nil, argonCacheTTL),
+	}
+	for _, entry := range entries {
+		switch tokenhash.KindOf(entry) {
+		case tokenhash.SHA256:
+			if digest, err := tokenhash.SHA256Digest(entry); err == nil {
+				ts.sha256[digest] = struct{}{}
+			}
+		case tokenhash.Argon2id:
+			ts.argon2 = append(ts.argon2, entry)
+		default:
+			ts.plain[entry] = struct{}{}
+		}
+	}
+	return ts
+}
+
+func (ts *tokenSet) len() int {
+	return len(ts.plain) + len(ts.sha256) + len(ts.argon2)
+}
+
+// verify reports whether token is accepted. It waits for an argon2id slot
+// when needed and returns false if ctx is done first.
+func (ts *tokenSet) verify(ctx context.Context, token string) bool {
+	if _, ok := ts.plain[token]; ok {
+		return true
+	}
+	if _, ok := ts.sha256[tokenhash.SumSHA256(token)]; ok {
+		return true
+	}
+	if len(ts.argon2) == 0 {
+		return false
+	}
+
+	key := sha256.Sum256([]byte(token))
+	if _, ok := ts.results.Get(key); ok {
+		return true
+	}
+
+	select {
+	case argonSlots <- struct{}{}:
+	case <-ctx.Done():
+		return false
+	}
+	defer func() { <-argonSlots }()
+	for _, entry := range ts.argon2 {
+		if tokenhash.VerifyArgon2id(entry, token) {
+			ts.results.Add(key, struct{}{})
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,104 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/web3tea/piecehub/config"
+	"github.com/web3tea/piecehub/internal/jobs"
+	"github.com/web3tea/piecehub/internal/meta"
+	"github.com/web3tea/piecehub/internal/retention"
+	"github.com/web3tea/piecehub/internal/tracing"
+	"github.com/web3tea/piecehub/storage"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+const testPiece = "baga6ea4seaqcdvksqaisozwlbj7lbmczqqj4o2prabkom7ivuwav6hhqrjf6yma"
+
+func spanAttr(s sdktrace.ReadOnlySpan, key attribute.Key) string {
+	for _, kv := range s.Attributes() {
+		if kv.Key == key {
+			return kv.Value.Emit()
+		}
+	}
+	return ""
+}
+
+func TestRequestSpans(t *testing.T) {
+	ctx := context.Background()
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	defer tp.Shutdown(ctx)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, testPiece), []byte("piece data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.DefaultConfig
+	cfg.Disks = []config.DiskConfig{{Name: "disk1", RootDir: root}}
+
+	store, err := storage.NewManager(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jobMgr, err := jobs.NewManager(&cfg.Jobs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jobMgr.Shutdown(ctx)
+	metaStore, err := meta.Open("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reaper := retention.New(&cfg.Retention, store, metaStore, jobMgr, nil)
+	handler := NewHandler(&cfg, store, jobMgr, metaStore, reaper, nil, nil)
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := httptest.NewRequest(http.MethodGet, "/pieces?id="+testPiece, nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+
+	var httpSpan, lookupSpan, storageSpan sdktrace.ReadOnlySpan
+	for _, s := range recorder.Ended() {
+		switch {
+		case s.Name() == "GET /pieces":
+			httpSpan = s
+		case s.Name() == "StorageManager.Locate":
+			lookupSpan = s
+		case spanAttr(s, tracing.AttrStorage) == "disk1" && spanAttr(s, tracing.AttrPieceCID) == testPiece:
+			storageSpan = s
+		}
+	}
+	if httpSpan == nil || lookupSpan == nil || storageSpan == nil {
+		t.Fatalf("missing spans: http %v, lookup %v, storage %v", httpSpan != nil, lookupSpan != nil, storageSpan != nil)
+	}
+
+	if got := httpSpan.SpanContext().TraceID().String(); got != traceID {
+		t.Errorf("http span trace id %s, want %s from traceparent", got, traceID)
+	}
+	if got := spanAttr(httpSpan, tracing.AttrPieceCID); got != testPiece {
+		t.Errorf("http span piece %q, want %q", got, testPiece)
+	}
+	if got := spanAttr(lookupSpan, tracing.AttrPieceCID); got != testPiece {
+		t.Errorf("lookup span piece %q, want %q", got, testPiece)
+	}
+	for _, s := range []sdktrace.ReadOnlySpan{lookupSpan, storageSpan} {
+		if s.SpanContext().TraceID() != httpSpan.SpanContext().TraceID() {
+			t.Errorf("span %s not in the request trace", s.Name())
+		}
+	}
+}
@@ -0,0 +1,214 @@
+// Package client is a Go client for the piecehub HTTP API.
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNotFound = errors.New("not found")
+	ErrExists   = errors.New("piece already exists")
+)
+
+// StatusError is returned for unexpected HTTP responses.
+type StatusError struct {
+	Code    int
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("piecehub: %d %s: %s", e.Code, http.StatusText(e.Code), e.Message)
+}
+
+type Client struct {
+	baseURL   *url.URL
+	token     string
+	scheme    string
+	http      *http.Client
+	retries   int
+	retryWait time.Duration
+}
+
+type Option func(*Client)
+
+// WithToken authenticates requests with token.
+func WithToken(token string) Option {
+	return func(c *Client) {
+		c.token = token
+	}
+}
+
+// WithAuthScheme sets the Authorization scheme: "Bearer" (default),
+// "Token", "Basic", or "" to send the bare token.
+func WithAuthScheme(scheme string) Option {
+	return func(c *Client) {
+		c.scheme = scheme
+	}
+}
+
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		c.http = hc
+	}
+}
+
+// WithRetries sets how often idempotent requests and interrupted downloads
+// are retried, waiting wait between attempts unless the server sends
+// Retry-After.
+func WithRetries(retries int, wait time.Duration) Option {
+	return func(c *Client) {
+		c.retries = retries
+		c.retryWait = wait
+	}
+}
+
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(strings.TrimSuffix(baseURL, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("parse base url: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("base url must be http or https: %s", baseURL)
+	}
+
+	c := &Client{
+		baseURL:   u,
+		scheme:    "Bearer",
+		http:      http.DefaultClient,
+		retries:   3,
+		retryWait: time.Second,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+func (c *Client) url(path string, query url.Values) string {
+	u := *c.baseURL
+	u.Path += path
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+func (c *Client) newRequest(ctx context.Context, method, path string, query url.Values, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.url(path, query), body)
+	if err != nil {
+		return nil, err
+	}
+	if c.token != "" {
+		if c.scheme == "" {
+			req.Header.Set("Authorization", c.token)
+		} else {
+			req.Header.Set("Authorization", c.scheme+" "+c.token)
+		}
+	}
+	return req, nil
+}
+
+// do sends a request built by newReq, retrying idempotent requests on
+// network errors, 429 and 5xx responses.
+func (c *Client) do(ctx context.Context, newReq func() (*http.Request, error)) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := newReq()
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := c.http.Do(req)
+		retryable := req.Method == http.MethodGet || req.Method == http.MethodHead
+		if attempt >= c.retries || !retryable {
+			return resp, err
+		}
+
+		wait := c.retryWait
+		if err == nil {
+			if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < 500 {
+				return resp, nil
+			}
+			if s, perr := strconv.Atoi(resp.Header.Get("Retry-After")); perr == nil {
+				wait = time.Duration(s) * time.Second
+			}
+			resp.Body.Close()
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func checkResponse(resp *http.Response, expected ...int) error {
+	for _, code := range expected {
+		if resp.StatusCode == code {
+			return nil
+		}
+	}
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		return ErrNotFound
+	case http.StatusConflict:
+		return ErrExists
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return &StatusError{Code: resp.StatusCode, Message: strings.TrimSpace(string(msg))}
+}
+
+// getJSON fetches path and decodes the response into out.
+func (c *Client) getJSON(ctx context.Context, path string, query url.Values, out any) error {
+	resp, err := c.do(ctx, func() (*http.Request, error) {
+		return c.newRequest(ctx, http.MethodGet, path, query, nil)
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := checkResponse(resp, http.StatusOK); err != nil {
+		return err
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// postJSON posts in as JSON to path and decodes the response into out.
+func (c *Client) postJSON(ctx context.Context, path string, in, out any) error {
+	return c.sendJSON(ctx, http.MethodPost, path, in, out)
+}
+
+// sendJSON sends in as JSON to path and decodes the response into out.
+func (c *Client) sendJSON(ctx context.Context, method, path string, in, out any) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := c.newRequest(ctx, method, path, nil, strings.NewReader(string(body)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := checkResponse(resp, http.StatusOK, http.StatusAccepted); err != nil {
+		return err
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
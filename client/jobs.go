@@ -0,0 +1,107 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// ErrJobFinished is returned when cancelling a job that has already ended.
+var ErrJobFinished = errors.New("job already finished")
+
+// Job states.
+const (
+	JobQueued   = "queued"
+	JobRunning  = "running"
+	JobDone     = "done"
+	JobFailed   = "failed"
+	JobCanceled = "canceled"
+)
+
+// Job is a background operation on the server.
+type Job struct {
+	ID         string          `json:"id"`
+	Type       string          `json:"type"`
+	State      string          `json:"state"`
+	Params     json.RawMessage `json:"params,omitempty"`
+	Result     json.RawMessage `json:"result,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	Bytes      int64           `json:"bytes"`
+	Total      int64           `json:"total,omitempty"`
+	ETASeconds int64           `json:"etaSeconds,omitempty"`
+	Created    time.Time       `json:"created"`
+	Started    *time.Time      `json:"started,omitempty"`
+	Finished   *time.Time      `json:"finished,omitempty"`
+}
+
+// Ended reports whether the job has finished.
+func (j *Job) Ended() bool {
+	return j.State == JobDone || j.State == JobFailed || j.State == JobCanceled
+}
+
+// DecodeResult decodes the result of a done job into v.
+func (j *Job) DecodeResult(v any) error {
+	if j.State != JobDone {
+		return errors.New("job is " + j.State)
+	}
+	return json.Unmarshal(j.Result, v)
+}
+
+// Job returns a job by id.
+func (c *Client) Job(ctx context.Context, id string) (*Job, error) {
+	var job Job
+	if err := c.getJSON(ctx, "/jobs/"+id, nil, &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// Jobs lists jobs of type typ, or all jobs when typ is empty.
+func (c *Client) Jobs(ctx context.Context, typ string) ([]Job, error) {
+	query := url.Values{}
+	if typ != "" {
+		query.Set("type", typ)
+	}
+	var jobs []Job
+	if err := c.getJSON(ctx, "/jobs", query, &jobs); err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+// CancelJob cancels a queued or running job.
+func (c *Client) CancelJob(ctx context.Context, id string) error {
+	req, err := c.newRequest(ctx, http.MethodDelete, "/jobs/"+id, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusConflict {
+		return ErrJobFinished
+	}
+	return checkResponse(resp, http.StatusOK)
+}
+
+// WaitJob polls a job every interval until it has finished.
+func (c *Client) WaitJob(ctx context.Context, id string, interval time.Duration) (*Job, error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		job, err := c.Job(ctx, id)
+		if err != nil || job.Ended() {
+			return job, err
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
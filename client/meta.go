@@ -0,0 +1,40 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// PieceMeta is metadata attached to a piece.
+type PieceMeta struct {
+	PayloadCID  string            `json:"payloadCid,omitempty"`
+	PayloadSize int64             `json:"payloadSize,omitempty"`
+	DealIDs     []uint64          `json:"dealIds,omitempty"`
+	Client      string            `json:"client,omitempty"`
+	SourceURL   string            `json:"sourceUrl,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	// Created is set by the server when the metadata is first stored.
+	Created         time.Time  `json:"created"`
+	Expiration      *time.Time `json:"expiration,omitempty"`
+	ExpirationEpoch int64      `json:"expirationEpoch,omitempty"`
+}
+
+// GetMeta returns the metadata of a piece, or ErrNotFound.
+func (c *Client) GetMeta(ctx context.Context, pieceCid string) (*PieceMeta, error) {
+	var m PieceMeta
+	if err := c.getJSON(ctx, "/pieces/"+pieceCid+"/meta", nil, &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// PutMeta replaces the metadata of a stored piece and returns it as
+// stored.
+func (c *Client) PutMeta(ctx context.Context, pieceCid string, m *PieceMeta) (*PieceMeta, error) {
+	var stored PieceMeta
+	if err := c.sendJSON(ctx, http.MethodPut, "/pieces/"+pieceCid+"/meta", m, &stored); err != nil {
+		return nil, err
+	}
+	return &stored, nil
+}
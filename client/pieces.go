@@ -0,0 +1,268 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Stat returns the size of a piece, or ErrNotFound.
+func (c *Client) Stat(ctx context.Context, pieceCid string) (int64, error) {
+	resp, err := c.do(ctx, func() (*http.Request, error) {
+		return c.newRequest(ctx, http.MethodHead, "/pieces", url.Values{"id": {pieceCid}}, nil)
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if err := checkResponse(resp, http.StatusOK); err != nil {
+		return 0, err
+	}
+	return resp.ContentLength, nil
+}
+
+// Get opens a piece for reading from offset. length limits the range, or
+// -1 reads to the end. The caller must close the reader.
+func (c *Client) Get(ctx context.Context, pieceCid string, offset, length int64) (io.ReadCloser, error) {
+	resp, err := c.do(ctx, func() (*http.Request, error) {
+		req, err := c.newRequest(ctx, http.MethodGet, "/pieces", url.Values{"id": {pieceCid}}, nil)
+		if err != nil {
+			return nil, err
+		}
+		if offset > 0 || length >= 0 {
+			rng := "bytes=" + strconv.FormatInt(offset, 10) + "-"
+			if length >= 0 {
+				rng += strconv.FormatInt(offset+length-1, 10)
+			}
+			req.Header.Set("Range", rng)
+		}
+		return req, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkResponse(resp, http.StatusOK, http.StatusPartialContent); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	if offset > 0 && resp.StatusCode != http.StatusPartialContent {
+		resp.Body.Close()
+		return nil, fmt.Errorf("server ignored range request")
+	}
+	return resp.Body, nil
+}
+
+// Download copies a piece into w starting at offset, resuming with range
+// requests when the transfer is interrupted. It returns the number of
+// bytes written.
+func (c *Client) Download(ctx context.Context, pieceCid string, w io.Writer, offset int64) (int64, error) {
+	size, err := c.Stat(ctx, pieceCid)
+	if err != nil {
+		return 0, err
+	}
+
+	var written int64
+	for attempt := 0; offset+written < size; attempt++ {
+		body, err := c.Get(ctx, pieceCid, offset+written, -1)
+		if err != nil {
+			return written, err
+		}
+		n, err := io.Copy(w, body)
+		body.Close()
+		written += n
+
+		if err == nil && offset+written >= size {
+			break
+		}
+		if err == nil {
+			err = io.ErrUnexpectedEOF
+		}
+		if ctx.Err() != nil || attempt >= c.retries {
+			return written, fmt.Errorf("download interrupted at %d of %d bytes: %w", offset+written, size, err)
+		}
+		if n > 0 {
+			// progress resets the retry budget
+			attempt = -1
+		}
+	}
+	return written, nil
+}
+
+// PieceInfo describes a stored piece.
+type PieceInfo struct {
+	PieceCID string     `json:"pieceCid"`
+	Size     int64      `json:"size"`
+	Storage  string     `json:"storage"`
+	Meta     *PieceMeta `json:"meta,omitempty"`
+}
+
+// Put uploads a piece to storage, or to the only configured storage when
+// storage is empty. size is the length of r, or -1 if unknown. The server
+// verifies the commP of the data against pieceCid and rejects mismatches.
+// Uploads are streamed and never retried.
+func (c *Client) Put(ctx context.Context, pieceCid, storage string, r io.Reader, size int64) (*PieceInfo, error) {
+	query := url.Values{"id": {pieceCid}}
+	if storage != "" {
+		query.Set("storage", storage)
+	}
+	req, err := c.newRequest(ctx, http.MethodPut, "/pieces", query, r)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if size >= 0 {
+		req.ContentLength = size
+		if size == 0 {
+			req.Body = http.NoBody
+		}
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if err := checkResponse(resp, http.StatusCreated); err != nil {
+		return nil, err
+	}
+	var info PieceInfo
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// Delete removes a piece, or returns ErrNotFound.
+func (c *Client) Delete(ctx context.Context, pieceCid string) error {
+	req, err := c.newRequest(ctx, http.MethodDelete, "/pieces", url.Values{"id": {pieceCid}}, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkResponse(resp, http.StatusNoContent)
+}
+
+// List returns the pieces of storage, or of all storages when storage is
+// empty.
+func (c *Client) List(ctx context.Context, storage string) ([]PieceInfo, error) {
+	return c.ListPieces(ctx, &ListOptions{Storage: storage})
+}
+
+// ListOptions selects pieces to list. Metadata filters only return pieces
+// with matching metadata.
+type ListOptions struct {
+	Storage    string
+	Labels     map[string]string
+	Client     string
+	DealID     uint64
+	PayloadCID string
+	// ExpiresBefore matches pieces expiring before it.
+	ExpiresBefore time.Time
+}
+
+// ListPieces returns the pieces selected by opts with their metadata.
+func (c *Client) ListPieces(ctx context.Context, opts *ListOptions) ([]PieceInfo, error) {
+	query := url.Values{}
+	if opts.Storage != "" {
+		query.Set("storage", opts.Storage)
+	}
+	for k, v := range opts.Labels {
+		query.Add("label", k+"="+v)
+	}
+	if opts.Client != "" {
+		query.Set("client", opts.Client)
+	}
+	if opts.DealID != 0 {
+		query.Set("deal", strconv.FormatUint(opts.DealID, 10))
+	}
+	if opts.PayloadCID != "" {
+		query.Set("payloadCid", opts.PayloadCID)
+	}
+	if !opts.ExpiresBefore.IsZero() {
+		query.Set("expiresBefore", opts.ExpiresBefore.Format(time.RFC3339))
+	}
+
+	var pieces []PieceInfo
+	if err := c.getJSON(ctx, "/pieces/list", query, &pieces); err != nil {
+		return nil, err
+	}
+	return pieces, nil
+}
+
+// PieceStat reports whether and where a piece is stored.
+type PieceStat struct {
+	PieceCID string `json:"pieceCid"`
+	Found    bool   `json:"found"`
+	Size     int64  `json:"size,omitempty"`
+	Storage  string `json:"storage,omitempty"`
+}
+
+// StatMany looks up many pieces in one request, returning their stats in
+// the order of pieceCids. The server accepts up to 10000 pieces per call.
+func (c *Client) StatMany(ctx context.Context, pieceCids []string) ([]PieceStat, error) {
+	var stats []PieceStat
+	if err := c.postJSON(ctx, "/pieces/stat", pieceCids, &stats); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// GenerateCarRequest asks the server to generate a test piece.
+type GenerateCarRequest struct {
+	Size        int64  `json:"size"`
+	StorageName string `json:"storageName"`
+	// Seed makes the piece reproducible; a random seed is used when nil.
+	Seed      *uint64 `json:"seed,omitempty"`
+	Mode      string  `json:"mode,omitempty"`
+	BlockSize int64   `json:"blockSize,omitempty"`
+	Fanout    int     `json:"fanout,omitempty"`
+	Files     int     `json:"files,omitempty"`
+	// Async is set by StartGenerateCar.
+	Async bool `json:"async,omitempty"`
+}
+
+type GenerateCarResponse struct {
+	PieceCID    string `json:"pieceCid"`
+	PieceSize   uint64 `json:"pieceSize"`
+	PayloadSize uint64 `json:"payloadSize"`
+	CarSize     uint64 `json:"carSize"`
+	CarCID      string `json:"carCid"`
+	Seed        uint64 `json:"seed"`
+}
+
+// GenerateCar calls POST /debug/generate-car and waits for the piece. Large
+// pieces may take longer than the server's write timeout; use
+// StartGenerateCar for those.
+func (c *Client) GenerateCar(ctx context.Context, req *GenerateCarRequest) (*GenerateCarResponse, error) {
+	body := *req
+	body.Async = false
+	var resp GenerateCarResponse
+	if err := c.postJSON(ctx, "/debug/generate-car", &body, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// StartGenerateCar starts generating a piece as a background job. The
+// result of the finished job decodes into a GenerateCarResponse.
+func (c *Client) StartGenerateCar(ctx context.Context, req *GenerateCarRequest) (*Job, error) {
+	body := *req
+	body.Async = true
+	var job Job
+	if err := c.postJSON(ctx, "/debug/generate-car", &body, &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
@@ -0,0 +1,46 @@
+package client
+
+import (
+	"context"
+	"time"
+)
+
+// RetentionRecord is one action of a retention sweep on one copy of a
+// piece.
+type RetentionRecord struct {
+	Time    time.Time `json:"time"`
+	Piece   string    `json:"piece"`
+	Storage string    `json:"storage"`
+	Size    int64     `json:"size"`
+	Action  string    `json:"action"`
+	Target  string    `json:"target,omitempty"`
+	Expiry  time.Time `json:"expiry"`
+	Reason  string    `json:"reason"`
+	DryRun  bool      `json:"dryRun,omitempty"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// RetentionReport is the result of a finished retention job.
+type RetentionReport struct {
+	DryRun  bool              `json:"dryRun"`
+	Checked int               `json:"checked"`
+	InGrace int               `json:"inGrace"`
+	Expired int               `json:"expired"`
+	Failed  int               `json:"failed"`
+	Bytes   int64             `json:"bytes"`
+	Actions []RetentionRecord `json:"actions"`
+}
+
+// StartRetention starts a retention sweep as a background job. With dryRun
+// the sweep only reports what it would delete or move. The result of the
+// finished job decodes into a RetentionReport.
+func (c *Client) StartRetention(ctx context.Context, dryRun bool) (*Job, error) {
+	body := struct {
+		DryRun bool `json:"dryRun"`
+	}{dryRun}
+	var job Job
+	if err := c.postJSON(ctx, "/admin/retention", &body, &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
@@ -0,0 +1,98 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+)
+
+// ListStorages returns the configured storage names.
+func (c *Client) ListStorages(ctx context.Context) ([]string, error) {
+	var names []string
+	if err := c.getJSON(ctx, "/storages", nil, &names); err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+type GateStatus struct {
+	Limit  int   `json:"limit"`
+	Active int   `json:"active"`
+	Queued int64 `json:"queued"`
+}
+
+type Status struct {
+	Transfers GateStatus            `json:"transfers"`
+	Storages  map[string]GateStatus `json:"storages"`
+}
+
+// Status returns the transfer admission status.
+func (c *Client) Status(ctx context.Context) (*Status, error) {
+	var st Status
+	if err := c.getJSON(ctx, "/status", nil, &st); err != nil {
+		return nil, err
+	}
+	return &st, nil
+}
+
+type ReloadResult struct {
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+	Updated []string `json:"updated"`
+}
+
+// Reload asks the server to reload its configuration.
+func (c *Client) Reload(ctx context.Context) (*ReloadResult, error) {
+	var res ReloadResult
+	if err := c.postJSON(ctx, "/admin/reload", struct{}{}, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+type CheckResult struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+type Readiness struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+// Ready returns the readiness report. A not-ready server is not an error;
+// check Readiness.Status.
+func (c *Client) Ready(ctx context.Context) (*Readiness, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, "/readyz", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if err := checkResponse(resp, http.StatusOK, http.StatusServiceUnavailable); err != nil {
+		return nil, err
+	}
+	var r Readiness
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// Healthy reports whether the server process is alive.
+func (c *Client) Healthy(ctx context.Context) error {
+	req, err := c.newRequest(ctx, http.MethodGet, "/healthz", nil, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkResponse(resp, http.StatusOK)
+}
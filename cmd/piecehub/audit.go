@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/urfave/cli/v2"
+	"github.com/web3tea/piecehub/internal/audit"
+)
+
+var auditCmd = &cli.Command{
+	Name:  "audit",
+	Usage: "work with the audit log",
+	Subcommands: []*cli.Command{
+		{
+			Name:      "verify",
+			Usage:     "check the hash chain of an audit log and its rotated files",
+			ArgsUsage: "<file>",
+			Action: func(c *cli.Context) error {
+				if c.NArg() != 1 {
+					return fmt.Errorf("expected the audit log path")
+				}
+				path := c.Args().First()
+				files, err := audit.Backups(path)
+				if err != nil {
+					return err
+				}
+				files = append(files, path)
+
+				prev := ""
+				total := 0
+				for _, file := range files {
+					f, err := os.Open(file)
+					if errors.Is(err, os.ErrNotExist) && file == path && len(files) > 1 {
+						// rotated just now
+						continue
+					}
+					if err != nil {
+						return err
+					}
+					last, n, err := audit.Verify(f, prev)
+					f.Close()
+					total += n
+					if err != nil {
+						return fmt.Errorf("%s: %w", file, err)
+					}
+					prev = last
+				}
+				fmt.Printf("%d events in %d files verified\n", total, len(files))
+				return nil
+			},
+		},
+	},
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/urfave/cli/v2"
+	"github.com/web3tea/piecehub/internal/car"
+)
+
+var commpCmd = &cli.Command{
+	Name:      "commp",
+	Usage:     "compute the piece commitment of a file, - for stdin",
+	ArgsUsage: "<file|->",
+	Flags: []cli.Flag{
+		&cli.IntFlag{
+			Name:  "workers",
+			Usage: "goroutines hashing the piece, 0 for one per CPU",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		if c.NArg() != 1 {
+			return fmt.Errorf("expected one file")
+		}
+
+		var rdr io.Reader = os.Stdin
+		if path := c.Args().First(); path != "-" {
+			f, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			rdr = f
+		}
+		cp, err := car.ParallelCommpReader(rdr, c.Int("workers"))
+		if err != nil {
+			return err
+		}
+		v2, err := car.PieceCIDV2(cp)
+		if err != nil {
+			return err
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 1, ' ', 0)
+		fmt.Fprintf(tw, "PieceCID:\t%s\n", cp.PieceCID)
+		fmt.Fprintf(tw, "PieceCIDv2:\t%s\n", v2)
+		fmt.Fprintf(tw, "Payload size:\t%d\n", cp.PayloadSize)
+		fmt.Fprintf(tw, "Padded piece size:\t%d\n", cp.PieceSize)
+		fmt.Fprintf(tw, "Unpadded piece size:\t%d\n", cp.PieceSize.Unpadded())
+		return tw.Flush()
+	},
+}
+
+var carCmd = &cli.Command{
+	Name:  "car",
+	Usage: "inspect, verify and generate CAR files",
+	Subcommands: []*cli.Command{
+		carGenerateCmd,
+		{
+			Name:      "inspect",
+			Usage:     "print the version, roots, block count and codecs of a CAR",
+			ArgsUsage: "<file>",
+			Action: func(c *cli.Context) error {
+				if c.NArg() != 1 {
+					return fmt.Errorf("expected one file")
+				}
+				info, err := car.Inspect(c.Args().First(), false)
+				if err != nil {
+					return err
+				}
+				printCarInfo(os.Stdout, info)
+				return nil
+			},
+		},
+		{
+			Name:      "verify",
+			Usage:     "check that every block of a CAR matches its CID",
+			ArgsUsage: "<file>",
+			Action: func(c *cli.Context) error {
+				if c.NArg() != 1 {
+					return fmt.Errorf("expected one file")
+				}
+				info, err := car.Inspect(c.Args().First(), true)
+				if err != nil {
+					return fmt.Errorf("verify failed: %v", err)
+				}
+				if !info.RootsPresent {
+					return fmt.Errorf("verify failed: roots are missing from the CAR")
+				}
+				fmt.Printf("ok: %d blocks verified\n", info.BlockCount)
+				return nil
+			},
+		},
+	},
+}
+
+func printCarInfo(w io.Writer, info *car.Info) {
+	tw := tabwriter.NewWriter(w, 0, 4, 1, ' ', 0)
+	fmt.Fprintf(tw, "Version:\t%d\n", info.Version)
+	for i, root := range info.Roots {
+		fmt.Fprintf(tw, "Root %d:\t%s\n", i, root)
+	}
+	fmt.Fprintf(tw, "Roots present:\t%t\n", info.RootsPresent)
+	fmt.Fprintf(tw, "Blocks:\t%d\n", info.BlockCount)
+	fmt.Fprintf(tw, "Block length:\tmin %d, avg %d, max %d\n", info.MinBlockLength, info.AvgBlockLength, info.MaxBlockLength)
+	fmt.Fprintf(tw, "CID length:\tmin %d, avg %d, max %d\n", info.MinCidLength, info.AvgCidLength, info.MaxCidLength)
+	if info.HasIndex {
+		fmt.Fprintf(tw, "Index:\t%s\n", info.IndexCodec)
+	} else {
+		fmt.Fprintf(tw, "Index:\tnone\n")
+	}
+	tw.Flush()
+
+	fmt.Fprintln(w, "Codecs:")
+	printHistogram(w, info.CodecCounts)
+	fmt.Fprintln(w, "Multihashes:")
+	printHistogram(w, info.MhTypeCounts)
+}
+
+// printHistogram prints counts by descending frequency.
+func printHistogram[K interface {
+	comparable
+	fmt.Stringer
+}](w io.Writer, counts map[K]uint64) {
+	keys := make([]K, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i].String() < keys[j].String()
+	})
+
+	tw := tabwriter.NewWriter(w, 0, 4, 1, ' ', 0)
+	for _, k := range keys {
+		fmt.Fprintf(tw, "  %s\t%d\n", k, counts[k])
+	}
+	tw.Flush()
+}
+
+var carGenerateCmd = &cli.Command{
+	Name:  "generate",
+	Usage: "generate a deterministic test CAR and print its root and commP",
+	Flags: []cli.Flag{
+		&cli.Int64Flag{
+			Name:     "size",
+			Usage:    "payload size in bytes",
+			Required: true,
+		},
+		&cli.Uint64Flag{
+			Name:  "seed",
+			Usage: "seed of the generated data, random when omitted",
+		},
+		&cli.StringFlag{
+			Name:  "mode",
+			Usage: "raw (flat raw blocks) or unixfs (file and directory DAGs)",
+			Value: car.ModeRaw,
+		},
+		&cli.Int64Flag{
+			Name:  "block-size",
+			Usage: "leaf block size in bytes",
+			Value: car.DefaultBlockSize,
+		},
+		&cli.IntFlag{
+			Name:  "fanout",
+			Usage: "maximum links per unixfs node",
+			Value: car.DefaultFanout,
+		},
+		&cli.IntFlag{
+			Name:  "files",
+			Usage: "number of unixfs files",
+			Value: 1,
+		},
+		&cli.StringFlag{
+			Name:     "output",
+			Aliases:  []string{"o"},
+			Usage:    "write to `FILE`, - for stdout",
+			Required: true,
+		},
+	},
+	Action: func(c *cli.Context) error {
+		opts := car.GenerateOptions{
+			Size:      c.Int64("size"),
+			Seed:      c.Uint64("seed"),
+			Mode:      c.String("mode"),
+			BlockSize: c.Int64("block-size"),
+			Fanout:    c.Int("fanout"),
+			Files:     c.Int("files"),
+		}
+		if !c.IsSet("seed") {
+			opts.Seed = car.RandomSeed()
+		}
+
+		// the summary goes to stderr when the CAR is written to stdout
+		out, info := os.Stdout, os.Stdout
+		if path := c.String("output"); path != "-" {
+			f, err := os.Create(path)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			out = f
+		} else {
+			info = os.Stderr
+		}
+
+		cw := car.NewParallelWriter(0)
+		root, err := car.WriteCar(c.Context, io.MultiWriter(out, cw), opts)
+		if err != nil {
+			return err
+		}
+		if out != os.Stdout {
+			if err := out.Close(); err != nil {
+				return err
+			}
+		}
+		cp, err := cw.Sum()
+		if err != nil {
+			return fmt.Errorf("compute commP: %v", err)
+		}
+
+		tw := tabwriter.NewWriter(info, 0, 4, 1, ' ', 0)
+		fmt.Fprintf(tw, "Root:\t%s\n", root)
+		fmt.Fprintf(tw, "PieceCID:\t%s\n", cp.PieceCID)
+		fmt.Fprintf(tw, "Piece size:\t%d\n", cp.PieceSize)
+		fmt.Fprintf(tw, "CAR size:\t%d\n", cp.PayloadSize)
+		fmt.Fprintf(tw, "Seed:\t%d\n", opts.Seed)
+		return tw.Flush()
+	},
+}
@@ -0,0 +1,614 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/urfave/cli/v2"
+	"github.com/web3tea/piecehub/client"
+	"github.com/web3tea/piecehub/internal/car"
+)
+
+var clientCmd = &cli.Command{
+	Name:  "client",
+	Usage: "access a running piecehub server",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:    "url",
+			Usage:   "server base url",
+			Value:   "http://localhost:8080",
+			EnvVars: []string{"PIECEHUB_URL"},
+		},
+		&cli.StringFlag{
+			Name:    "token",
+			Usage:   "access token",
+			EnvVars: []string{"PIECEHUB_TOKEN"},
+		},
+		&cli.StringFlag{
+			Name:  "auth-scheme",
+			Usage: "authorization scheme (Bearer, Token, Basic, or empty for the bare token)",
+			Value: "Bearer",
+		},
+		&cli.IntFlag{
+			Name:  "retries",
+			Usage: "retries for reads and interrupted downloads",
+			Value: 3,
+		},
+	},
+	Subcommands: []*cli.Command{
+		clientStatCmd,
+		clientGetCmd,
+		clientPutCmd,
+		clientLsCmd,
+		clientRmCmd,
+		clientJobCmd,
+		clientMetaCmd,
+		clientRetentionCmd,
+	},
+}
+
+func newClient(c *cli.Context) (*client.Client, error) {
+	return client.New(c.String("url"),
+		client.WithToken(c.String("token")),
+		client.WithAuthScheme(c.String("auth-scheme")),
+		client.WithRetries(c.Int("retries"), time.Second),
+	)
+}
+
+var clientStatCmd = &cli.Command{
+	Name:      "stat",
+	Usage:     "print the size of a piece, or the size and storage of several pieces",
+	ArgsUsage: "<pieceCid>...",
+	Action: func(c *cli.Context) error {
+		if c.NArg() == 0 {
+			return fmt.Errorf("expected at least one piece cid")
+		}
+		cl, err := newClient(c)
+		if err != nil {
+			return err
+		}
+		if c.NArg() == 1 {
+			size, err := cl.Stat(c.Context, c.Args().First())
+			if err != nil {
+				return err
+			}
+			fmt.Println(size)
+			return nil
+		}
+
+		stats, err := cl.StatMany(c.Context, c.Args().Slice())
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		missing := 0
+		for _, st := range stats {
+			if !st.Found {
+				missing++
+				fmt.Fprintf(tw, "%s\tmissing\t\n", st.PieceCID)
+				continue
+			}
+			fmt.Fprintf(tw, "%s\t%d\t%s\n", st.PieceCID, st.Size, st.Storage)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+		if missing > 0 {
+			return fmt.Errorf("%d of %d pieces missing", missing, len(stats))
+		}
+		return nil
+	},
+}
+
+var clientGetCmd = &cli.Command{
+	Name:      "get",
+	Usage:     "download a piece, resuming a partial file and verifying its commP",
+	ArgsUsage: "<pieceCid>",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:    "output",
+			Aliases: []string{"o"},
+			Usage:   "write to `FILE` (default: the piece cid, - for stdout)",
+		},
+		&cli.BoolFlag{
+			Name:  "no-resume",
+			Usage: "overwrite an existing file instead of resuming",
+		},
+		&cli.BoolFlag{
+			Name:  "no-verify",
+			Usage: "skip commP verification",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		if c.NArg() != 1 {
+			return fmt.Errorf("expected one piece cid")
+		}
+		pieceCid := c.Args().First()
+		pc, err := cid.Decode(pieceCid)
+		if err != nil {
+			return fmt.Errorf("invalid piece cid: %v", err)
+		}
+		cl, err := newClient(c)
+		if err != nil {
+			return err
+		}
+
+		output := c.String("output")
+		if output == "" {
+			output = pieceCid
+		}
+		verify := !c.Bool("no-verify")
+
+		if output == "-" {
+			cw := car.NewParallelWriter(0)
+			if _, err := cl.Download(c.Context, pieceCid, io.MultiWriter(os.Stdout, cw), 0); err != nil {
+				return err
+			}
+			if !verify {
+				return nil
+			}
+			sum, err := cw.Sum()
+			if err != nil {
+				return fmt.Errorf("compute commP: %v", err)
+			}
+			return checkCommP(pc, sum.PieceCID)
+		}
+
+		flags := os.O_WRONLY | os.O_CREATE
+		if c.Bool("no-resume") {
+			flags |= os.O_TRUNC
+		}
+		f, err := os.OpenFile(output, flags, 0644)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		offset, err := f.Seek(0, io.SeekEnd)
+		if err != nil {
+			return err
+		}
+		size, err := cl.Stat(c.Context, pieceCid)
+		if err != nil {
+			return err
+		}
+		if offset > size {
+			return fmt.Errorf("%s is larger than the piece (%d > %d bytes), use --no-resume", output, offset, size)
+		}
+		if offset < size {
+			if _, err := cl.Download(c.Context, pieceCid, f, offset); err != nil {
+				return err
+			}
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+
+		if !verify {
+			return nil
+		}
+		sum, err := car.Commp(output)
+		if err != nil {
+			return fmt.Errorf("compute commP: %v", err)
+		}
+		return checkCommP(pc, sum.PieceCID)
+	},
+}
+
+func checkCommP(expected, actual cid.Cid) error {
+	if !expected.Equals(actual) {
+		return fmt.Errorf("commP mismatch: expected %s, data has %s", expected, actual)
+	}
+	return nil
+}
+
+var clientPutCmd = &cli.Command{
+	Name:      "put",
+	Usage:     "compute the commP of a file and upload it as a piece",
+	ArgsUsage: "<file>",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "storage",
+			Usage: "target storage, may be omitted when the server has only one",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		if c.NArg() != 1 {
+			return fmt.Errorf("expected one file")
+		}
+		path := c.Args().First()
+		cl, err := newClient(c)
+		if err != nil {
+			return err
+		}
+
+		cp, err := car.Commp(path)
+		if err != nil {
+			return fmt.Errorf("compute commP: %v", err)
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		fi, err := f.Stat()
+		if err != nil {
+			return err
+		}
+
+		info, err := cl.Put(c.Context, cp.PieceCID.String(), c.String("storage"), f, fi.Size())
+		if err != nil {
+			return err
+		}
+		fmt.Println(info.PieceCID)
+		return nil
+	},
+}
+
+var clientLsCmd = &cli.Command{
+	Name:  "ls",
+	Usage: "list pieces",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "storage",
+			Usage: "only list pieces of this storage",
+		},
+		&cli.StringSliceFlag{
+			Name:  "label",
+			Usage: "only list pieces labelled key=value (repeatable)",
+		},
+		&cli.StringFlag{
+			Name:  "client",
+			Usage: "only list pieces of this deal client",
+		},
+		&cli.Uint64Flag{
+			Name:  "deal",
+			Usage: "only list pieces of this deal",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		cl, err := newClient(c)
+		if err != nil {
+			return err
+		}
+		labels, err := parseLabels(c.StringSlice("label"))
+		if err != nil {
+			return err
+		}
+		pieces, err := cl.ListPieces(c.Context, &client.ListOptions{
+			Storage: c.String("storage"),
+			Labels:  labels,
+			Client:  c.String("client"),
+			DealID:  c.Uint64("deal"),
+		})
+		if err != nil {
+			return err
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		for _, p := range pieces {
+			fmt.Fprintf(tw, "%s\t%d\t%s\n", p.PieceCID, p.Size, p.Storage)
+		}
+		return tw.Flush()
+	},
+}
+
+// parseLabels parses key=value pairs.
+func parseLabels(pairs []string) (map[string]string, error) {
+	if len(pairs) == 0 {
+		return nil, nil
+	}
+	labels := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok || k == "" {
+			return nil, fmt.Errorf("invalid label %q, expected key=value", pair)
+		}
+		labels[k] = v
+	}
+	return labels, nil
+}
+
+var clientRmCmd = &cli.Command{
+	Name:      "rm",
+	Usage:     "delete pieces",
+	ArgsUsage: "<pieceCid>...",
+	Action: func(c *cli.Context) error {
+		if c.NArg() == 0 {
+			return fmt.Errorf("expected at least one piece cid")
+		}
+		cl, err := newClient(c)
+		if err != nil {
+			return err
+		}
+
+		var errs []error
+		for _, pieceCid := range c.Args().Slice() {
+			if err := cl.Delete(c.Context, pieceCid); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", pieceCid, err))
+			}
+		}
+		return errors.Join(errs...)
+	},
+}
+
+var clientJobCmd = &cli.Command{
+	Name:  "job",
+	Usage: "list, show and cancel background jobs",
+	Subcommands: []*cli.Command{
+		{
+			Name:  "ls",
+			Usage: "list jobs",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "type",
+					Usage: "only list jobs of this type",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				cl, err := newClient(c)
+				if err != nil {
+					return err
+				}
+				jobs, err := cl.Jobs(c.Context, c.String("type"))
+				if err != nil {
+					return err
+				}
+
+				tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+				for _, j := range jobs {
+					progress := ""
+					if j.Total > 0 {
+						progress = fmt.Sprintf("%d%%", j.Bytes*100/j.Total)
+					}
+					fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", j.ID, j.Type, j.State, progress, j.Created.Local().Format(time.DateTime))
+				}
+				return tw.Flush()
+			},
+		},
+		{
+			Name:      "get",
+			Usage:     "print a job as JSON",
+			ArgsUsage: "<id>",
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:  "wait",
+					Usage: "wait for the job to finish",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				if c.NArg() != 1 {
+					return fmt.Errorf("expected one job id")
+				}
+				cl, err := newClient(c)
+				if err != nil {
+					return err
+				}
+				var job *client.Job
+				if c.Bool("wait") {
+					job, err = cl.WaitJob(c.Context, c.Args().First(), time.Second)
+				} else {
+					job, err = cl.Job(c.Context, c.Args().First())
+				}
+				if err != nil {
+					return err
+				}
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				return enc.Encode(job)
+			},
+		},
+		{
+			Name:      "cancel",
+			Usage:     "cancel jobs",
+			ArgsUsage: "<id>...",
+			Action: func(c *cli.Context) error {
+				if c.NArg() == 0 {
+					return fmt.Errorf("expected at least one job id")
+				}
+				cl, err := newClient(c)
+				if err != nil {
+					return err
+				}
+
+				var errs []error
+				for _, id := range c.Args().Slice() {
+					if err := cl.CancelJob(c.Context, id); err != nil {
+						errs = append(errs, fmt.Errorf("%s: %w", id, err))
+					}
+				}
+				return errors.Join(errs...)
+			},
+		},
+	},
+}
+
+var clientMetaCmd = &cli.Command{
+	Name:  "meta",
+	Usage: "show and edit piece metadata",
+	Subcommands: []*cli.Command{
+		{
+			Name:      "get",
+			Usage:     "print the metadata of a piece as JSON",
+			ArgsUsage: "<pieceCid>",
+			Action: func(c *cli.Context) error {
+				if c.NArg() != 1 {
+					return fmt.Errorf("expected one piece cid")
+				}
+				cl, err := newClient(c)
+				if err != nil {
+					return err
+				}
+				m, err := cl.GetMeta(c.Context, c.Args().First())
+				if err != nil {
+					return err
+				}
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				return enc.Encode(m)
+			},
+		},
+		{
+			Name:      "set",
+			Usage:     "update the metadata of a piece, keeping fields that are not given",
+			ArgsUsage: "<pieceCid>",
+			Flags: []cli.Flag{
+				&cli.StringSliceFlag{
+					Name:  "label",
+					Usage: "set label key=value, or remove it with key= (repeatable)",
+				},
+				&cli.Uint64SliceFlag{
+					Name:  "deal",
+					Usage: "add a deal id (repeatable)",
+				},
+				&cli.StringFlag{
+					Name:  "client",
+					Usage: "deal client address",
+				},
+				&cli.StringFlag{
+					Name:  "source-url",
+					Usage: "url the piece was fetched from",
+				},
+				&cli.StringFlag{
+					Name:  "payload-cid",
+					Usage: "root cid of the piece payload",
+				},
+				&cli.TimestampFlag{
+					Name:   "expiration",
+					Usage:  "when the piece is no longer needed (RFC3339)",
+					Layout: time.RFC3339,
+				},
+				&cli.Int64Flag{
+					Name:  "expiration-epoch",
+					Usage: "chain epoch after which the piece is no longer needed",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				if c.NArg() != 1 {
+					return fmt.Errorf("expected one piece cid")
+				}
+				labels, err := parseLabels(c.StringSlice("label"))
+				if err != nil {
+					return err
+				}
+				cl, err := newClient(c)
+				if err != nil {
+					return err
+				}
+
+				pieceCid := c.Args().First()
+				m, err := cl.GetMeta(c.Context, pieceCid)
+				if errors.Is(err, client.ErrNotFound) {
+					m = &client.PieceMeta{}
+				} else if err != nil {
+					return err
+				}
+
+				for k, v := range labels {
+					if v == "" {
+						delete(m.Labels, k)
+						continue
+					}
+					if m.Labels == nil {
+						m.Labels = make(map[string]string)
+					}
+					m.Labels[k] = v
+				}
+				for _, id := range c.Uint64Slice("deal") {
+					if !slices.Contains(m.DealIDs, id) {
+						m.DealIDs = append(m.DealIDs, id)
+					}
+				}
+				if c.IsSet("client") {
+					m.Client = c.String("client")
+				}
+				if c.IsSet("source-url") {
+					m.SourceURL = c.String("source-url")
+				}
+				if c.IsSet("payload-cid") {
+					m.PayloadCID = c.String("payload-cid")
+				}
+				if c.IsSet("expiration") {
+					m.Expiration = c.Timestamp("expiration")
+				}
+				if c.IsSet("expiration-epoch") {
+					m.ExpirationEpoch = c.Int64("expiration-epoch")
+				}
+
+				if _, err := cl.PutMeta(c.Context, pieceCid, m); err != nil {
+					return err
+				}
+				return nil
+			},
+		},
+	},
+}
+
+var clientRetentionCmd = &cli.Command{
+	Name:  "retention",
+	Usage: "start a sweep deleting or moving expired pieces",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "only report what would be done",
+		},
+		&cli.BoolFlag{
+			Name:  "wait",
+			Usage: "wait for the sweep and print its actions",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		cl, err := newClient(c)
+		if err != nil {
+			return err
+		}
+		job, err := cl.StartRetention(c.Context, c.Bool("dry-run"))
+		if err != nil {
+			return err
+		}
+		if !c.Bool("wait") {
+			fmt.Println(job.ID)
+			return nil
+		}
+
+		job, err = cl.WaitJob(c.Context, job.ID, time.Second)
+		if err != nil {
+			return err
+		}
+		var report client.RetentionReport
+		if err := job.DecodeResult(&report); err != nil {
+			return fmt.Errorf("%w: %s", err, job.Error)
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		for _, a := range report.Actions {
+			outcome := "ok"
+			if a.Error != "" {
+				outcome = a.Error
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%s\n", a.Piece, a.Storage, a.Action, a.Target, a.Size, outcome)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+		dry := ""
+		if report.DryRun {
+			dry = " (dry run)"
+		}
+		fmt.Printf("checked %d, in grace %d, expired %d, failed %d, %d bytes%s\n",
+			report.Checked, report.InGrace, report.Expired, report.Failed, report.Bytes, dry)
+		if report.Failed > 0 {
+			return fmt.Errorf("%d actions failed", report.Failed)
+		}
+		return nil
+	},
+}
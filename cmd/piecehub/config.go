@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/BurntSushi/toml"
+	"github.com/urfave/cli/v2"
+	"github.com/web3tea/piecehub/config"
+)
+
+const sampleConfig = `# piecehub configuration
+# Strings may reference environment variables as ${VAR}.
+
+# Further config files, relative to this one.
+# include = ["conf.d/*.toml"]
+
+[server]
+address = ":8080"
+read_timeout = 600
+write_timeout = 600
+# tokens = ["sha256:..."]
+# tokens_file = "/run/secrets/piecehub-tokens"
+# tls_cert = "/etc/piecehub/server.crt"
+# tls_key = "/etc/piecehub/server.key"
+
+[[disks]]
+name = "local1"
+root_dir = "/data/pieces1"
+create = false
+require_mount = true
+
+# [[s3s]]
+# name = "remote1"
+# endpoint = "s3.amazonaws.com"
+# region = "us-east-1"
+# bucket = "my-pieces"
+# prefix = ""
+# use_ssl = true
+# access_key = "${S3_ACCESS_KEY}"
+# secret_key_file = "/run/secrets/s3-secret-key"
+`
+
+var configCmd = &cli.Command{
+	Name:  "config",
+	Usage: "validate or create configuration files",
+	Subcommands: []*cli.Command{
+		{
+			Name:  "validate",
+			Usage: "check the configuration given by --config and report all problems",
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:  "print",
+					Usage: "print the effective merged configuration with secrets redacted",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				path, err := configPath(c)
+				if err != nil {
+					return err
+				}
+
+				cfg, warnings, err := config.Load(path)
+				for _, w := range warnings {
+					fmt.Fprintln(os.Stderr, "warning:", w)
+				}
+				if err != nil {
+					return fmt.Errorf("invalid config:\n%v", err)
+				}
+
+				if c.Bool("print") {
+					return toml.NewEncoder(os.Stdout).Encode(cfg.Redacted())
+				}
+				fmt.Fprintln(os.Stderr, "config ok:", path)
+				return nil
+			},
+		},
+		{
+			Name:      "init",
+			Usage:     "write a sample configuration file",
+			ArgsUsage: "[path]",
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:  "force",
+					Usage: "overwrite an existing file",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				path := c.Args().First()
+				if path == "" {
+					path = "config.toml"
+				}
+
+				flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+				if c.Bool("force") {
+					flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+				}
+				f, err := os.OpenFile(path, flags, 0600)
+				if errors.Is(err, os.ErrExist) {
+					return fmt.Errorf("%s already exists, use --force to overwrite", path)
+				}
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+
+				if _, err := f.WriteString(sampleConfig); err != nil {
+					return err
+				}
+				fmt.Fprintln(os.Stderr, "wrote", path)
+				return nil
+			},
+		},
+	},
+}
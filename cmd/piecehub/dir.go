@@ -16,6 +16,10 @@ var dirCmd = &cli.Command{
 			Name:  "listen",
 			Usage: "server listen address",
 		},
+		&cli.BoolFlag{
+			Name:  "create",
+			Usage: "create missing directories",
+		},
 	},
 	Action: func(c *cli.Context) error {
 		paths := c.Args().Slice()
@@ -30,14 +34,14 @@ var dirCmd = &cli.Command{
 			cfg.Disks = append(cfg.Disks, config.DiskConfig{
 				Name:    path,
 				RootDir: path,
+				Create:  c.Bool("create"),
 			})
 		}
 
-		tokens := c.StringSlice("tokens")
-		if len(tokens) > 0 {
-			cfg.Server.Tokens = append(cfg.Server.Tokens, tokens...)
+		if err := applyServerFlags(c, &cfg.Server); err != nil {
+			return err
 		}
 
-		return runServer(&cfg)
+		return runServer(&cfg, nil)
 	},
 }
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"github.com/web3tea/piecehub/config"
+	"github.com/web3tea/piecehub/internal/car"
+	"github.com/web3tea/piecehub/storage"
+	"github.com/web3tea/piecehub/storage/disk"
+	"github.com/web3tea/piecehub/storage/s3"
+)
+
+const (
+	importAuto = "auto"
+	importLink = "link"
+	importMove = "move"
+	importCopy = "copy"
+)
+
+// importRecord is one line of the import manifest.
+type importRecord struct {
+	Path       string    `json:"path"`
+	PieceCID   string    `json:"pieceCid"`
+	PieceSize  uint64    `json:"pieceSize"`
+	PayloadCID string    `json:"payloadCid,omitempty"`
+	Size       int64     `json:"size"`
+	Storage    string    `json:"storage"`
+	Method     string    `json:"method"`
+	Time       time.Time `json:"time"`
+}
+
+var importCmd = &cli.Command{
+	Name:      "import",
+	Usage:     "import existing files and directories as pieces, keyed by their commP",
+	ArgsUsage: "<path>...",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:     "storage",
+			Usage:    "target storage from the configuration",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:  "mode",
+			Usage: "how files are added: auto (hard link, else copy), link, move (rename, else copy and remove), copy",
+			Value: importAuto,
+		},
+		&cli.StringFlag{
+			Name:  "manifest",
+			Usage: "append the manifest to `FILE` instead of stdout",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		if c.NArg() == 0 {
+			return fmt.Errorf("expected at least one path")
+		}
+		mode := c.String("mode")
+		switch mode {
+		case importAuto, importLink, importMove, importCopy:
+		default:
+			return fmt.Errorf("unknown mode %q", mode)
+		}
+
+		path, err := configPath(c)
+		if err != nil {
+			return err
+		}
+		cfg, err := config.LoadConfig(path)
+		if err != nil {
+			return fmt.Errorf("load config: %v", err)
+		}
+		st, err := openStorage(cfg, c.String("storage"))
+		if err != nil {
+			return err
+		}
+		if _, ok := st.(*disk.DiskStorage); !ok && mode == importLink {
+			return fmt.Errorf("storage %s does not support links", st.Name())
+		}
+
+		var manifest io.Writer = os.Stdout
+		if name := c.String("manifest"); name != "" {
+			f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			manifest = f
+		}
+		enc := json.NewEncoder(manifest)
+
+		var imported, failed int
+		for _, root := range c.Args().Slice() {
+			err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+				if p != root && strings.HasPrefix(d.Name(), ".") {
+					if d.IsDir() {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+				if !d.Type().IsRegular() {
+					return nil
+				}
+
+				rec, err := importFile(c.Context, st, p, mode)
+				if err != nil {
+					slog.Error("import failed", "path", p, "err", err)
+					failed++
+					return nil
+				}
+				imported++
+				return enc.Encode(rec)
+			})
+			if err != nil {
+				return fmt.Errorf("walk %s: %v", root, err)
+			}
+		}
+
+		slog.Info("import finished", "imported", imported, "failed", failed)
+		if failed > 0 {
+			return fmt.Errorf("%d files failed to import", failed)
+		}
+		return nil
+	},
+}
+
+// importFile adds the file at path to st under its PieceCID.
+func importFile(ctx context.Context, st storage.Storage, path, mode string) (*importRecord, error) {
+	cp, err := car.Commp(path)
+	if err != nil {
+		return nil, fmt.Errorf("compute commP: %v", err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	rec := &importRecord{
+		Path:      path,
+		PieceCID:  cp.PieceCID.String(),
+		PieceSize: uint64(cp.PieceSize),
+		Size:      fi.Size(),
+		Storage:   st.Name(),
+		Time:      time.Now().UTC(),
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		rec.Path = abs
+	}
+	// files that are not CARs are imported without a payload cid
+	if roots, err := car.Roots(path); err == nil && len(roots) > 0 {
+		rec.PayloadCID = roots[0].String()
+	}
+
+	if size, err := st.Stats(ctx, rec.PieceCID); err == nil {
+		if size != fi.Size() {
+			return nil, fmt.Errorf("piece %s already exists with size %d", rec.PieceCID, size)
+		}
+		rec.Method = "exists"
+		return rec, nil
+	}
+
+	rec.Method, err = storeFile(ctx, st, rec.PieceCID, path, fi.Size(), mode)
+	if err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+// storeFile links, moves or copies path into st and returns the method used.
+func storeFile(ctx context.Context, st storage.Storage, name, path string, size int64, mode string) (string, error) {
+	if ds, ok := st.(*disk.DiskStorage); ok && mode != importCopy {
+		move := mode == importMove
+		err := ds.Link(name, path, move)
+		if err == nil {
+			if move {
+				return importMove, nil
+			}
+			return importLink, nil
+		}
+		if mode == importLink {
+			return "", fmt.Errorf("link: %v", err)
+		}
+		slog.Debug("link failed, copying", "path", path, "err", err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if err := st.Write(ctx, name, f, size); err != nil {
+		return "", fmt.Errorf("copy: %v", err)
+	}
+	if mode == importMove {
+		if err := os.Remove(path); err != nil {
+			return "", fmt.Errorf("remove source: %v", err)
+		}
+	}
+	return importCopy, nil
+}
+
+// openStorage creates the storage backend configured under name.
+func openStorage(cfg *config.Config, name string) (storage.Storage, error) {
+	for _, diskCfg := range cfg.Disks {
+		if diskCfg.Name == name {
+			return disk.New(&diskCfg)
+		}
+	}
+	for _, s3Cfg := range cfg.S3s {
+		if s3Cfg.Name == name {
+			return s3.New(&s3Cfg)
+		}
+	}
+	return nil, fmt.Errorf("storage not found: %s", name)
+}
@@ -2,8 +2,9 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
@@ -14,6 +15,13 @@ import (
 	"github.com/urfave/cli/v2"
 	"github.com/web3tea/piecehub/api"
 	"github.com/web3tea/piecehub/config"
+	"github.com/web3tea/piecehub/internal/audit"
+	"github.com/web3tea/piecehub/internal/jobs"
+	"github.com/web3tea/piecehub/internal/logging"
+	"github.com/web3tea/piecehub/internal/meta"
+	"github.com/web3tea/piecehub/internal/retention"
+	"github.com/web3tea/piecehub/internal/tlsutil"
+	"github.com/web3tea/piecehub/internal/tracing"
 	"github.com/web3tea/piecehub/storage"
 	"github.com/web3tea/piecehub/version"
 )
@@ -35,54 +43,151 @@ func main() {
 				Name:  "token",
 				Usage: "token for accessing the service, can specify multiple tokens",
 			},
+			&cli.StringSliceFlag{
+				Name:  "anonymous-permissions",
+				Usage: "permissions of anonymous callers when no tokens are configured (read, write, admin)",
+			},
 			&cli.StringFlag{
 				Name:    "log-level",
 				Value:   "info",
 				Usage:   "log level (debug, info, warn, error)",
 				EnvVars: []string{"LOG_LEVEL"},
 			},
+			&cli.StringFlag{
+				Name:    "log-format",
+				Value:   "text",
+				Usage:   "log format (text, json)",
+				EnvVars: []string{"LOG_FORMAT"},
+			},
+		},
+		Before: func(c *cli.Context) error {
+			return logging.Setup(c.String("log-level"), c.String("log-format"))
 		},
 		Commands: []*cli.Command{
 			dirCmd,
 			s3Cmd,
+			cleanupUploadsCmd,
+			tokenCmd,
+			configCmd,
+			clientCmd,
+			importCmd,
+			commpCmd,
+			carCmd,
+			auditCmd,
 		},
 		Action: func(c *cli.Context) error {
-			configPath := c.String("config")
-			if !filepath.IsAbs(configPath) {
-				pwd, err := os.Getwd()
+			configPath, err := configPath(c)
+			if err != nil {
+				return err
+			}
+
+			loader := func() (*config.Config, error) {
+				cfg, err := config.LoadConfig(configPath)
 				if err != nil {
-					return fmt.Errorf("get working directory: %v", err)
+					return nil, err
+				}
+				if err := applyServerFlags(c, &cfg.Server); err != nil {
+					return nil, err
 				}
-				configPath = filepath.Join(pwd, configPath)
+				return cfg, nil
 			}
 
-			cfg, err := config.LoadConfig(configPath)
+			cfg, err := loader()
 			if err != nil {
 				return fmt.Errorf("load config: %v", err)
 			}
 
-			tokens := c.StringSlice("token")
-			if len(tokens) > 0 {
-				cfg.Server.Tokens = append(cfg.Server.Tokens, tokens...)
-			}
-
-			return runServer(cfg)
+			return runServer(cfg, loader)
 		},
 	}
 	if err := app.Run(os.Args); err != nil {
-		log.Fatal(err)
+		slog.Error(err.Error())
+		os.Exit(1)
+	}
+}
+
+// configPath returns the absolute path of the --config file.
+func configPath(c *cli.Context) (string, error) {
+	path := c.String("config")
+	if !filepath.IsAbs(path) {
+		pwd, err := os.Getwd()
+		if err != nil {
+			return "", fmt.Errorf("get working directory: %v", err)
+		}
+		path = filepath.Join(pwd, path)
 	}
+	return path, nil
+}
+
+// applyServerFlags adds the global --token and --anonymous-permissions
+// flags to cfg.
+func applyServerFlags(c *cli.Context, cfg *config.ServerConfig) error {
+	cfg.Tokens = append(cfg.Tokens, c.StringSlice("token")...)
+	if c.IsSet("anonymous-permissions") {
+		perms := c.StringSlice("anonymous-permissions")
+		if err := config.ValidatePermissions(perms); err != nil {
+			return fmt.Errorf("anonymous-permissions: %v", err)
+		}
+		cfg.AnonymousPermissions = perms
+	}
+	return nil
 }
 
-func runServer(cfg *config.Config) error {
+func runServer(cfg *config.Config, loader api.ConfigLoader) error {
+	shutdownTracing, err := tracing.Setup(context.Background(), &cfg.Tracing, nil)
+	if err != nil {
+		return fmt.Errorf("setup tracing: %v", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			slog.Error("shutdown tracing", "err", err)
+		}
+	}()
+
 	store, err := storage.NewManager(cfg)
 	if err != nil {
 		return fmt.Errorf("create storage manager: %v", err)
 	}
 
-	handler := api.NewHandler(cfg, store)
+	// closed last, after jobs have recorded how they ended
+	auditLog, err := audit.Open(&cfg.Audit)
+	if err != nil {
+		return fmt.Errorf("open audit log: %v", err)
+	}
+	defer auditLog.Close()
+
+	jobMgr, err := jobs.NewManager(&cfg.Jobs)
+	if err != nil {
+		return fmt.Errorf("create job manager: %v", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := jobMgr.Shutdown(ctx); err != nil {
+			slog.Error("shutdown jobs", "err", err)
+		}
+	}()
+
+	metaStore, err := meta.Open(cfg.Metadata.Dir)
+	if err != nil {
+		return fmt.Errorf("open metadata store: %v", err)
+	}
+
+	reaper := retention.New(&cfg.Retention, store, metaStore, jobMgr, auditLog)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go reaper.Run(ctx)
 
-	log.Printf("Starting server on %s", cfg.Server.Address)
+	handler := api.NewHandler(cfg, store, jobMgr, metaStore, reaper, auditLog, loader)
+	defer handler.Close()
+
+	if loader != nil {
+		go reloadOnSignal(handler)
+	}
+
+	slog.Info("starting server", "address", cfg.Server.Address, "tls", cfg.Server.TLSCert != "")
 	if err := startServer(cfg, handler); err != nil {
 		return fmt.Errorf("start server: %v", err)
 	}
@@ -98,6 +203,15 @@ func startServer(cfg *config.Config, handler http.Handler) error {
 		WriteTimeout: time.Duration(cfg.Server.WriteTimeout) * time.Second,
 	}
 
+	tlsEnabled := cfg.Server.TLSCert != ""
+	if tlsEnabled {
+		reloader, err := tlsutil.NewReloader(cfg.Server.TLSCert, cfg.Server.TLSKey, cfg.Server.ClientCA)
+		if err != nil {
+			return fmt.Errorf("load tls: %v", err)
+		}
+		server.TLSConfig = reloader.TLSConfig()
+	}
+
 	go func() {
 		sigCh := make(chan os.Signal, 1)
 		signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
@@ -107,9 +221,29 @@ func startServer(cfg *config.Config, handler http.Handler) error {
 		defer cancel()
 
 		if err := server.Shutdown(ctx); err != nil {
-			log.Printf("Server shutdown error: %v", err)
+			slog.Error("server shutdown", "err", err)
 		}
 	}()
 
-	return server.ListenAndServe()
+	var err error
+	if tlsEnabled {
+		err = server.ListenAndServeTLS("", "")
+	} else {
+		err = server.ListenAndServe()
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+func reloadOnSignal(handler *api.Handler) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGHUP)
+	for range sigCh {
+		slog.Info("received SIGHUP, reloading config")
+		if _, err := handler.Reload(); err != nil {
+			slog.Error("config reload failed", "err", err)
+		}
+	}
 }
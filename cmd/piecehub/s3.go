@@ -29,10 +29,30 @@ var s3Cmd = &cli.Command{
 			Name:  "region",
 			Usage: "s3 region",
 		},
+		&cli.StringFlag{
+			Name:  "session-token",
+			Usage: "s3 session token for temporary credentials",
+		},
+		&cli.StringSliceFlag{
+			Name:  "credentials",
+			Usage: "credential sources tried in order (static, env, file, iam)",
+		},
 		&cli.BoolFlag{
 			Name:  "ssl",
 			Usage: "use ssl for s3",
 		},
+		&cli.StringFlag{
+			Name:  "bucket-lookup",
+			Usage: "bucket addressing style (auto, dns, path)",
+		},
+		&cli.StringFlag{
+			Name:  "ca-file",
+			Usage: "PEM bundle of CAs to trust for the s3 endpoint",
+		},
+		&cli.BoolFlag{
+			Name:  "insecure-skip-verify",
+			Usage: "skip TLS certificate verification for the s3 endpoint",
+		},
 		&cli.StringFlag{
 			Name:  "listen",
 			Usage: "server listen address",
@@ -53,22 +73,26 @@ var s3Cmd = &cli.Command{
 		}
 		for _, bucket := range buckets {
 			cfg.S3s = append(cfg.S3s, config.S3Config{
-				Name:      bucket,
-				Endpoint:  c.String("endpoint"),
-				Region:    c.String("region"),
-				Bucket:    bucket,
-				Prefix:    c.String("prefix"),
-				AccessKey: c.String("ak"),
-				SecretKey: c.String("sk"),
-				UseSSL:    c.Bool("ssl"),
+				Name:               bucket,
+				Endpoint:           c.String("endpoint"),
+				Region:             c.String("region"),
+				Bucket:             bucket,
+				Prefix:             c.String("prefix"),
+				AccessKey:          c.String("ak"),
+				SecretKey:          c.String("sk"),
+				SessionToken:       c.String("session-token"),
+				Credentials:        c.StringSlice("credentials"),
+				UseSSL:             c.Bool("ssl"),
+				BucketLookup:       c.String("bucket-lookup"),
+				CAFile:             c.String("ca-file"),
+				InsecureSkipVerify: c.Bool("insecure-skip-verify"),
 			})
 		}
 
-		tokens := c.StringSlice("tokens")
-		if len(tokens) > 0 {
-			cfg.Server.Tokens = append(cfg.Server.Tokens, tokens...)
+		if err := applyServerFlags(c, &cfg.Server); err != nil {
+			return err
 		}
 
-		return runServer(&cfg)
+		return runServer(&cfg, nil)
 	},
 }
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+	"github.com/web3tea/piecehub/internal/tokenhash"
+)
+
+var tokenCmd = &cli.Command{
+	Name:  "token",
+	Usage: "manage access tokens",
+	Subcommands: []*cli.Command{
+		{
+			Name:      "hash",
+			Usage:     "print the config entry for a token, read from stdin when omitted",
+			ArgsUsage: "[token]",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "algo",
+					Usage: "hash algorithm (sha256, argon2id)",
+					Value: "argon2id",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				token := c.Args().First()
+				if token == "" {
+					line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+					if err != nil && line == "" {
+						return fmt.Errorf("read token: %v", err)
+					}
+					token = strings.TrimSpace(line)
+				}
+				if token == "" {
+					return fmt.Errorf("no token provided")
+				}
+
+				entry, err := tokenhash.Hash(token, c.String("algo"))
+				if err != nil {
+					return err
+				}
+				fmt.Println(entry)
+				return nil
+			},
+		},
+	},
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"github.com/web3tea/piecehub/config"
+	"github.com/web3tea/piecehub/storage/s3"
+)
+
+var cleanupUploadsCmd = &cli.Command{
+	Name:  "cleanup-uploads",
+	Usage: "abort stale incomplete multipart uploads on the configured s3 storages",
+	Flags: []cli.Flag{
+		&cli.DurationFlag{
+			Name:  "older-than",
+			Usage: "only abort uploads started before this long ago",
+			Value: 24 * time.Hour,
+		},
+		&cli.StringSliceFlag{
+			Name:  "storage",
+			Usage: "s3 storage names to clean, default all",
+		},
+		&cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "list stale uploads without aborting them",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		path, err := configPath(c)
+		if err != nil {
+			return err
+		}
+		cfg, err := config.LoadConfig(path)
+		if err != nil {
+			return fmt.Errorf("load config: %v", err)
+		}
+
+		names := c.StringSlice("storage")
+		for _, s3Cfg := range cfg.S3s {
+			if len(names) > 0 && !slices.Contains(names, s3Cfg.Name) {
+				continue
+			}
+
+			store, err := s3.New(&s3Cfg)
+			if err != nil {
+				return fmt.Errorf("create s3 storage %s: %v", s3Cfg.Name, err)
+			}
+
+			uploads, err := store.AbortIncompleteUploads(c.Context, c.Duration("older-than"), c.Bool("dry-run"))
+			for _, u := range uploads {
+				fmt.Printf("%s\t%s\t%s\t%s\n", s3Cfg.Name, u.Key, u.UploadID, u.Initiated.Format(time.RFC3339))
+			}
+			if err != nil {
+				return fmt.Errorf("clean %s: %v", s3Cfg.Name, err)
+			}
+		}
+		return nil
+	},
+}
@@ -2,37 +2,205 @@ package config
 
 import (
 	"fmt"
+	"log/slog"
+	"path/filepath"
+	"sort"
 
 	"github.com/BurntSushi/toml"
 )
 
 type Config struct {
-	Server ServerConfig `toml:"server"`
-	Disks  []DiskConfig `toml:"disks"`
-	S3s    []S3Config   `toml:"s3s"`
+	// Include lists glob patterns of further config files, relative to
+	// this one.
+	Include   []string        `toml:"include"`
+	Server    ServerConfig    `toml:"server"`
+	Tracing   TracingConfig   `toml:"tracing"`
+	Limits    LimitsConfig    `toml:"limits"`
+	Jobs      JobsConfig      `toml:"jobs"`
+	Metadata  MetadataConfig  `toml:"metadata"`
+	Retention RetentionConfig `toml:"retention"`
+	Audit     AuditConfig     `toml:"audit"`
+	Disks     []DiskConfig    `toml:"disks"`
+	S3s       []S3Config      `toml:"s3s"`
 }
 
 type ServerConfig struct {
-	Address      string   `toml:"address"`
-	ReadTimeout  int      `toml:"read_timeout"`
-	WriteTimeout int      `toml:"write_timeout"`
-	Tokens       []string `toml:"tokens"`
+	Address      string             `toml:"address"`
+	ReadTimeout  int                `toml:"read_timeout"`
+	WriteTimeout int                `toml:"write_timeout"`
+	Tokens       []string           `toml:"tokens"`
+	TokensFile   string             `toml:"tokens_file"`
+	TLSCert      string             `toml:"tls_cert"`
+	TLSKey       string             `toml:"tls_key"`
+	ClientCA     string             `toml:"client_ca"`
+	ClientCerts  []ClientCertConfig `toml:"client_certs"`
+	// AnonymousPermissions are granted to every request when no tokens or
+	// client certificates are configured. Defaults to read only.
+	AnonymousPermissions []string `toml:"anonymous_permissions"`
+}
+
+// ClientCertConfig grants permissions to client certificates verified
+// against ClientCA. Subject matches either the certificate common name or
+// the full subject, e.g. "CN=curio,O=example".
+type ClientCertConfig struct {
+	Subject     string   `toml:"subject"`
+	Permissions []string `toml:"permissions"`
+}
+
+// TracingConfig configures OTLP/HTTP trace export. Empty fields fall back
+// to the standard OTEL_EXPORTER_OTLP_* environment variables.
+type TracingConfig struct {
+	Enabled     bool    `toml:"enabled"`
+	Endpoint    string  `toml:"endpoint"`
+	Insecure    bool    `toml:"insecure"`
+	ServiceName string  `toml:"service_name"`
+	SampleRatio float64 `toml:"sample_ratio"`
+}
+
+// LimitsConfig caps request rates, egress bandwidth and concurrent
+// transfers. Each scope is enforced independently and a zero value disables
+// that limit.
+type LimitsConfig struct {
+	Global     RateLimit `toml:"global"`
+	PerToken   RateLimit `toml:"per_token"`
+	PerIP      RateLimit `toml:"per_ip"`
+	PerStorage RateLimit `toml:"per_storage"`
+
+	// MaxTransfers and MaxStorageTransfers bound concurrent downloads and
+	// uploads, globally and per backend. Up to MaxQueue requests wait for a
+	// slot for at most QueueTimeout seconds before getting 503.
+	MaxTransfers        int `toml:"max_transfers"`
+	MaxStorageTransfers int `toml:"max_storage_transfers"`
+	MaxQueue            int `toml:"max_queue"`
+	QueueTimeout        int `toml:"queue_timeout"`
+}
+
+// JobsConfig configures background jobs such as CAR generation.
+type JobsConfig struct {
+	// StateDir keeps job records across restarts. Without it jobs are only
+	// kept in memory.
+	StateDir string `toml:"state_dir"`
+	// Retention is how long finished jobs are kept, in seconds.
+	Retention int `toml:"retention"`
+	// Concurrency limits the running jobs of each type, by type name.
+	// Types not listed use DefaultConcurrency; zero means no limit. Further
+	// jobs wait queued.
+	Concurrency        map[string]int `toml:"concurrency"`
+	DefaultConcurrency int            `toml:"default_concurrency"`
+}
+
+// MetadataConfig configures the piece metadata store.
+type MetadataConfig struct {
+	// Dir keeps piece metadata, one JSON file per piece. Without it
+	// metadata is only kept in memory.
+	Dir string `toml:"dir"`
+}
+
+// RetentionConfig configures the reaper that expires pieces once their
+// metadata says they are no longer needed.
+type RetentionConfig struct {
+	// Enabled sweeps every Interval seconds. Sweeps can also be started
+	// through the API when disabled.
+	Enabled  bool `toml:"enabled"`
+	Interval int  `toml:"interval"`
+	// Action is "delete" or "move", which moves expired pieces to
+	// ColdStorage.
+	Action      string `toml:"action"`
+	ColdStorage string `toml:"cold_storage"`
+	// GracePeriod is how many seconds after expiry pieces are kept.
+	GracePeriod int `toml:"grace_period"`
+	// DryRun only records what would be done.
+	DryRun bool            `toml:"dry_run"`
+	Rules  []RetentionRule `toml:"rules"`
+}
+
+// RetentionRule applies to pieces carrying all of Labels. The first
+// matching rule is used.
+type RetentionRule struct {
+	Labels map[string]string `toml:"labels"`
+	// MaxAge expires pieces this many seconds after their metadata was
+	// created, unless they expire earlier.
+	MaxAge int `toml:"max_age"`
+	// Keep exempts pieces from expiry.
+	Keep bool `toml:"keep"`
+}
+
+// AuditConfig configures the audit log of uploads, deletes, admin and
+// debug requests and retention actions.
+type AuditConfig struct {
+	// Path is the JSON lines file events are appended to. Auditing is off
+	// without it.
+	Path string `toml:"path"`
+	// MaxSize rotates the file before it grows past this many bytes, zero
+	// never rotates. MaxBackups limits the rotated files kept, zero keeps
+	// all of them.
+	MaxSize    int64 `toml:"max_size"`
+	MaxBackups int   `toml:"max_backups"`
+	// HashChain links every event to the hash of the previous one, so
+	// that edited or removed events are detected by `piecehub audit
+	// verify`.
+	HashChain bool `toml:"hash_chain"`
+}
+
+type RateLimit struct {
+	RequestsPerSecond float64 `toml:"requests_per_second"`
+	Burst             int     `toml:"burst"`
+	BytesPerSecond    int64   `toml:"bytes_per_second"`
 }
 
 type DiskConfig struct {
 	Name    string `toml:"name"`
 	RootDir string `toml:"root_dir"`
+	// Create allows creating RootDir when it does not exist.
+	Create bool `toml:"create"`
+	// RequireMount refuses RootDir unless it is a mountpoint, so an
+	// unmounted disk is not silently replaced by the root filesystem.
+	RequireMount bool `toml:"require_mount"`
 }
 
 type S3Config struct {
-	Name      string `toml:"name"`
-	Endpoint  string `toml:"endpoint"`
-	Region    string `toml:"region"`
-	Bucket    string `toml:"bucket"`
-	Prefix    string `toml:"prefix"`
-	AccessKey string `toml:"access_key"`
-	SecretKey string `toml:"secret_key"`
-	UseSSL    bool   `toml:"use_ssl"`
+	Name         string `toml:"name"`
+	Endpoint     string `toml:"endpoint"`
+	Region       string `toml:"region"`
+	Bucket       string `toml:"bucket"`
+	Prefix       string `toml:"prefix"`
+	AccessKey    string `toml:"access_key"`
+	SecretKey    string `toml:"secret_key"`
+	SessionToken string `toml:"session_token"`
+	UseSSL       bool   `toml:"use_ssl"`
+
+	// The *_file variants read the value from a file instead.
+	AccessKeyFile    string `toml:"access_key_file"`
+	SecretKeyFile    string `toml:"secret_key_file"`
+	SessionTokenFile string `toml:"session_token_file"`
+
+	// BucketLookup selects "path" or "dns" (virtual-host) addressing;
+	// "auto" or empty lets the client decide.
+	BucketLookup       string `toml:"bucket_lookup"`
+	CAFile             string `toml:"ca_file"`
+	InsecureSkipVerify bool   `toml:"insecure_skip_verify"`
+
+	// Credentials lists the credential sources tried in order: "static"
+	// (access_key/secret_key/session_token), "env" (AWS_* and MINIO_*
+	// variables), "file" (AWS shared credentials file) and "iam" (instance
+	// role, container credentials or web identity via
+	// AWS_WEB_IDENTITY_TOKEN_FILE). Defaults to "static" when access_key is
+	// set and to "env", "file", "iam" otherwise.
+	Credentials     []string `toml:"credentials"`
+	CredentialsFile string   `toml:"credentials_file"`
+	Profile         string   `toml:"profile"`
+
+	// PartSize is the multipart upload part size in bytes, 0 lets the
+	// client pick one from the object size. UploadConcurrency is the number
+	// of parts uploaded in parallel.
+	PartSize          uint64 `toml:"part_size"`
+	UploadConcurrency uint   `toml:"upload_concurrency"`
+
+	// ReadConcurrency enables parallel ranged GETs for full-piece reads
+	// when greater than 1, fetching ReadChunkSize bytes per request
+	// (default 16 MiB).
+	ReadChunkSize   int64 `toml:"read_chunk_size"`
+	ReadConcurrency int   `toml:"read_concurrency"`
 }
 
 var DefaultConfig = Config{
@@ -41,44 +209,112 @@ var DefaultConfig = Config{
 		ReadTimeout:  600,
 		WriteTimeout: 600,
 	},
+	Limits: LimitsConfig{
+		QueueTimeout: 30,
+	},
+	Jobs: JobsConfig{
+		Retention:          86400,
+		DefaultConcurrency: 1,
+	},
+	Retention: RetentionConfig{
+		Interval:    3600,
+		Action:      "delete",
+		GracePeriod: 86400,
+	},
+	Audit: AuditConfig{
+		MaxSize: 100 << 20,
+	},
+	Tracing: TracingConfig{
+		ServiceName: "piecehub",
+		SampleRatio: 1,
+	},
 }
 
 func LoadConfig(path string) (*Config, error) {
+	cfg, warnings, err := Load(path)
+	for _, w := range warnings {
+		slog.Warn("config: " + w)
+	}
+	return cfg, err
+}
+
+// Load reads path and its includes, resolves secrets and validates the
+// result. Problems that do not prevent running, such as unknown keys, are
+// returned as warnings, also alongside an error.
+func Load(path string) (*Config, []string, error) {
 	config := DefaultConfig
 
-	if _, err := toml.DecodeFile(path, &config); err != nil {
-		return nil, err
+	md, err := toml.DecodeFile(path, &config)
+	if err != nil {
+		return nil, nil, err
+	}
+	warnings := undecodedWarnings(path, md)
+
+	includeWarnings, err := decodeIncludes(path, &config)
+	warnings = append(warnings, includeWarnings...)
+	if err != nil {
+		return nil, warnings, err
+	}
+
+	if err := expandEnv(&config); err != nil {
+		return nil, warnings, err
+	}
+
+	if err := resolveSecretFiles(&config); err != nil {
+		return nil, warnings, err
 	}
 
 	if err := validateConfig(&config); err != nil {
-		return nil, err
+		return nil, warnings, err
 	}
 
-	return &config, nil
+	return &config, warnings, nil
 }
 
-func validateConfig(cfg *Config) error {
-	names := make(map[string]bool)
-
-	for _, disk := range cfg.Disks {
-		if disk.Name == "" {
-			return fmt.Errorf("disk name cannot be empty")
+// decodeIncludes decodes the files matched by the include globs, relative
+// to the main file, in order. Scalar settings in later files override
+// earlier ones while tokens and storages are appended.
+func decodeIncludes(path string, cfg *Config) ([]string, error) {
+	var warnings []string
+	for _, pattern := range cfg.Include {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(path), pattern)
 		}
-		if names[disk.Name] {
-			return fmt.Errorf("duplicate storage name: %s", disk.Name)
+		files, err := filepath.Glob(pattern)
+		if err != nil {
+			return warnings, fmt.Errorf("include %s: %w", pattern, err)
 		}
-		names[disk.Name] = true
-	}
+		sort.Strings(files)
 
-	for _, s3 := range cfg.S3s {
-		if s3.Name == "" {
-			return fmt.Errorf("s3 name cannot be empty")
-		}
-		if names[s3.Name] {
-			return fmt.Errorf("duplicate storage name: %s", s3.Name)
+		for _, file := range files {
+			tokens, clientCerts, disks, s3s := cfg.Server.Tokens, cfg.Server.ClientCerts, cfg.Disks, cfg.S3s
+			cfg.Server.Tokens, cfg.Server.ClientCerts, cfg.Disks, cfg.S3s = nil, nil, nil, nil
+			include := cfg.Include
+			cfg.Include = nil
+
+			md, err := toml.DecodeFile(file, cfg)
+			if err != nil {
+				return warnings, fmt.Errorf("include %s: %w", file, err)
+			}
+			if len(cfg.Include) > 0 {
+				return warnings, fmt.Errorf("include %s: nested includes are not supported", file)
+			}
+			warnings = append(warnings, undecodedWarnings(file, md)...)
+
+			cfg.Include = include
+			cfg.Server.Tokens = append(tokens, cfg.Server.Tokens...)
+			cfg.Server.ClientCerts = append(clientCerts, cfg.Server.ClientCerts...)
+			cfg.Disks = append(disks, cfg.Disks...)
+			cfg.S3s = append(s3s, cfg.S3s...)
 		}
-		names[s3.Name] = true
 	}
+	return warnings, nil
+}
 
-	return nil
+func undecodedWarnings(path string, md toml.MetaData) []string {
+	var warnings []string
+	for _, key := range md.Undecoded() {
+		warnings = append(warnings, fmt.Sprintf("%s: unknown key %q", path, key.String()))
+	}
+	return warnings
 }
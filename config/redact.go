@@ -0,0 +1,34 @@
+package config
+
+import (
+	"github.com/web3tea/piecehub/internal/tokenhash"
+)
+
+const redacted = "REDACTED"
+
+// Redacted returns a copy of cfg with plaintext tokens and S3 secrets
+// replaced, suitable for printing. Hashed tokens are kept.
+func (c *Config) Redacted() *Config {
+	out := *c
+
+	out.Server.Tokens = make([]string, len(c.Server.Tokens))
+	for i, token := range c.Server.Tokens {
+		if tokenhash.KindOf(token) == tokenhash.Plain {
+			token = redacted
+		}
+		out.Server.Tokens[i] = token
+	}
+
+	out.S3s = make([]S3Config, len(c.S3s))
+	for i, s3 := range c.S3s {
+		if s3.SecretKey != "" {
+			s3.SecretKey = redacted
+		}
+		if s3.SessionToken != "" {
+			s3.SessionToken = redacted
+		}
+		out.S3s[i] = s3
+	}
+
+	return &out
+}
@@ -0,0 +1,125 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/web3tea/piecehub/internal/tokenhash"
+)
+
+var envPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandEnv replaces ${VAR} in every string of the config with the value of
+// the environment variable VAR. Unset variables are an error so a missing
+// secret is not silently replaced by an empty string.
+func expandEnv(cfg *Config) error {
+	var missing []string
+	expand := func(s string) string {
+		return envPattern.ReplaceAllStringFunc(s, func(m string) string {
+			name := envPattern.FindStringSubmatch(m)[1]
+			v, ok := os.LookupEnv(name)
+			if !ok {
+				missing = append(missing, name)
+			}
+			return v
+		})
+	}
+
+	walkStrings(reflect.ValueOf(cfg).Elem(), expand)
+
+	if len(missing) > 0 {
+		return fmt.Errorf("undefined environment variables: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+func walkStrings(v reflect.Value, fn func(string) string) {
+	switch v.Kind() {
+	case reflect.String:
+		if v.CanSet() {
+			v.SetString(fn(v.String()))
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			walkStrings(v.Field(i), fn)
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			walkStrings(v.Index(i), fn)
+		}
+	case reflect.Pointer:
+		if !v.IsNil() {
+			walkStrings(v.Elem(), fn)
+		}
+	}
+}
+
+// resolveSecretFiles reads the *_file variants into their plain fields.
+func resolveSecretFiles(cfg *Config) error {
+	if cfg.Server.TokensFile != "" {
+		tokens, err := readLines(cfg.Server.TokensFile)
+		if err != nil {
+			return fmt.Errorf("read tokens_file: %w", err)
+		}
+		cfg.Server.Tokens = append(cfg.Server.Tokens, tokens...)
+	}
+
+	for i := range cfg.S3s {
+		s3 := &cfg.S3s[i]
+		for _, f := range []struct {
+			name  string
+			path  string
+			value *string
+		}{
+			{"access_key", s3.AccessKeyFile, &s3.AccessKey},
+			{"secret_key", s3.SecretKeyFile, &s3.SecretKey},
+			{"session_token", s3.SessionTokenFile, &s3.SessionToken},
+		} {
+			if f.path == "" {
+				continue
+			}
+			if *f.value != "" {
+				return fmt.Errorf("s3 %s: %s and %s_file are mutually exclusive", s3.Name, f.name, f.name)
+			}
+			b, err := os.ReadFile(f.path)
+			if err != nil {
+				return fmt.Errorf("s3 %s: read %s_file: %w", s3.Name, f.name, err)
+			}
+			*f.value = strings.TrimSpace(string(b))
+		}
+	}
+	return nil
+}
+
+// readLines returns the non-empty lines of a file, skipping # comments.
+func readLines(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var lines []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, sc.Err()
+}
+
+func validateTokens(tokens []string) error {
+	for i, token := range tokens {
+		if err := tokenhash.Validate(token); err != nil {
+			return fmt.Errorf("token %d: %w", i, err)
+		}
+	}
+	return nil
+}
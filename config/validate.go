@@ -0,0 +1,193 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+var validPermissions = map[string]bool{
+	"read":  true,
+	"write": true,
+	"admin": true,
+}
+
+var validCredentialSources = map[string]bool{
+	"static": true,
+	"env":    true,
+	"file":   true,
+	"iam":    true,
+}
+
+// ValidatePermissions checks that every permission is read, write or
+// admin.
+func ValidatePermissions(perms []string) error {
+	for _, p := range perms {
+		if !validPermissions[p] {
+			return fmt.Errorf("unknown permission %q", p)
+		}
+	}
+	return nil
+}
+
+// validateConfig checks the whole configuration and reports every problem
+// found.
+func validateConfig(cfg *Config) error {
+	var errs []error
+	errorf := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if cfg.Server.Address == "" {
+		errorf("server address cannot be empty")
+	}
+	if cfg.Server.ReadTimeout < 0 || cfg.Server.WriteTimeout < 0 {
+		errorf("server timeouts cannot be negative")
+	}
+	if err := validateTokens(cfg.Server.Tokens); err != nil {
+		errs = append(errs, err)
+	}
+	if (cfg.Server.TLSCert == "") != (cfg.Server.TLSKey == "") {
+		errorf("tls_cert and tls_key must be set together")
+	}
+	if cfg.Server.ClientCA != "" && cfg.Server.TLSCert == "" {
+		errorf("client_ca requires tls_cert and tls_key")
+	}
+	if len(cfg.Server.ClientCerts) > 0 && cfg.Server.ClientCA == "" {
+		errorf("client_certs requires client_ca")
+	}
+	for _, cc := range cfg.Server.ClientCerts {
+		if cc.Subject == "" {
+			errorf("client cert subject cannot be empty")
+		}
+		for _, p := range cc.Permissions {
+			if !validPermissions[p] {
+				errorf("client cert %s: unknown permission %q", cc.Subject, p)
+			}
+		}
+	}
+	if err := ValidatePermissions(cfg.Server.AnonymousPermissions); err != nil {
+		errorf("anonymous_permissions: %v", err)
+	}
+
+	if cfg.Tracing.SampleRatio < 0 || cfg.Tracing.SampleRatio > 1 {
+		errorf("tracing sample_ratio must be between 0 and 1")
+	}
+
+	for _, scope := range []struct {
+		name string
+		l    RateLimit
+	}{
+		{"global", cfg.Limits.Global},
+		{"per_token", cfg.Limits.PerToken},
+		{"per_ip", cfg.Limits.PerIP},
+		{"per_storage", cfg.Limits.PerStorage},
+	} {
+		if scope.l.RequestsPerSecond < 0 || scope.l.Burst < 0 || scope.l.BytesPerSecond < 0 {
+			errorf("limits.%s: values cannot be negative", scope.name)
+		}
+	}
+	if cfg.Limits.MaxTransfers < 0 || cfg.Limits.MaxStorageTransfers < 0 ||
+		cfg.Limits.MaxQueue < 0 || cfg.Limits.QueueTimeout < 0 {
+		errorf("limits: transfer limits cannot be negative")
+	}
+
+	if cfg.Jobs.Retention < 0 || cfg.Jobs.DefaultConcurrency < 0 {
+		errorf("jobs: retention and default_concurrency cannot be negative")
+	}
+	for typ, n := range cfg.Jobs.Concurrency {
+		if n < 0 {
+			errorf("jobs: concurrency of %s cannot be negative", typ)
+		}
+	}
+
+	names := make(map[string]bool)
+	roots := make(map[string]string)
+	for _, disk := range cfg.Disks {
+		if disk.Name == "" {
+			errorf("disk name cannot be empty")
+		}
+		if names[disk.Name] {
+			errorf("duplicate storage name: %s", disk.Name)
+		}
+		names[disk.Name] = true
+
+		if disk.RootDir == "" {
+			errorf("disk %s: root_dir cannot be empty", disk.Name)
+			continue
+		}
+		root, err := filepath.Abs(disk.RootDir)
+		if err != nil {
+			root = filepath.Clean(disk.RootDir)
+		}
+		if other, ok := roots[root]; ok {
+			errorf("disks %s and %s use the same root_dir %s", other, disk.Name, root)
+		}
+		roots[root] = disk.Name
+	}
+
+	locations := make(map[string]string)
+	for _, s3 := range cfg.S3s {
+		if s3.Name == "" {
+			errorf("s3 name cannot be empty")
+		}
+		if names[s3.Name] {
+			errorf("duplicate storage name: %s", s3.Name)
+		}
+		names[s3.Name] = true
+
+		if s3.Endpoint == "" || s3.Bucket == "" {
+			errorf("s3 %s: endpoint and bucket are required", s3.Name)
+		} else {
+			loc := s3.Endpoint + "/" + s3.Bucket + "/" + strings.Trim(s3.Prefix, "/")
+			if other, ok := locations[loc]; ok {
+				errorf("s3 %s and %s use the same bucket and prefix %s", other, s3.Name, loc)
+			}
+			locations[loc] = s3.Name
+		}
+		switch s3.BucketLookup {
+		case "", "auto", "dns", "path":
+		default:
+			errorf("s3 %s: invalid bucket_lookup %q", s3.Name, s3.BucketLookup)
+		}
+		if s3.PartSize != 0 && s3.PartSize < 5<<20 {
+			errorf("s3 %s: part_size must be at least 5 MiB", s3.Name)
+		}
+		if s3.ReadChunkSize < 0 || s3.ReadConcurrency < 0 {
+			errorf("s3 %s: read_chunk_size and read_concurrency cannot be negative", s3.Name)
+		}
+		for _, src := range s3.Credentials {
+			if !validCredentialSources[src] {
+				errorf("s3 %s: unknown credential source %q", s3.Name, src)
+			}
+		}
+		if s3.InsecureSkipVerify && !s3.UseSSL {
+			errorf("s3 %s: insecure_skip_verify requires use_ssl", s3.Name)
+		}
+	}
+
+	if cfg.Retention.Interval <= 0 || cfg.Retention.GracePeriod < 0 {
+		errorf("retention: interval must be positive and grace_period cannot be negative")
+	}
+	switch cfg.Retention.Action {
+	case "delete":
+	case "move":
+		if !names[cfg.Retention.ColdStorage] {
+			errorf("retention: cold_storage %q is not a configured storage", cfg.Retention.ColdStorage)
+		}
+	default:
+		errorf("retention: invalid action %q", cfg.Retention.Action)
+	}
+	for i, rule := range cfg.Retention.Rules {
+		if rule.MaxAge < 0 {
+			errorf("retention: rule %d: max_age cannot be negative", i+1)
+		}
+	}
+
+	if cfg.Audit.MaxSize < 0 || cfg.Audit.MaxBackups < 0 {
+		errorf("audit: max_size and max_backups cannot be negative")
+	}
+
+	return errors.Join(errs...)
+}
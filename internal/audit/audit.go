@@ -0,0 +1,339 @@
+// Package audit appends security relevant events, such as uploads,
+// deletes and admin requests, to a JSON lines file. The file is rotated by
+// size and events can be hash chained so that edits and deletions are
+// detected by Verify.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/web3tea/piecehub/config"
+)
+
+// Outcomes of events.
+const (
+	Success = "success"
+	Failure = "failure"
+	Denied  = "denied"
+)
+
+// Event is one line of the audit log.
+type Event struct {
+	Time      time.Time `json:"time"`
+	Action    string    `json:"action"`
+	Outcome   string    `json:"outcome"`
+	Identity  string    `json:"identity,omitempty"`
+	ClientIP  string    `json:"clientIp,omitempty"`
+	RequestID string    `json:"requestId,omitempty"`
+	Method    string    `json:"method,omitempty"`
+	Path      string    `json:"path,omitempty"`
+	Status    int       `json:"status,omitempty"`
+	Piece     string    `json:"piece,omitempty"`
+	Storage   string    `json:"storage,omitempty"`
+	Target    string    `json:"target,omitempty"`
+	Size      int64     `json:"size,omitempty"`
+	Job       string    `json:"job,omitempty"`
+	// Reason is why the system acted, e.g. why a piece expired.
+	Reason string `json:"reason,omitempty"`
+	// DryRun events record what would have been done.
+	DryRun bool `json:"dryRun,omitempty"`
+	// Count is the number of occurrences an aggregated event stands for.
+	Count int    `json:"count,omitempty"`
+	Error string `json:"error,omitempty"`
+	// Prev is the hash of the previous event and Hash the hash of this
+	// one, when hash chaining is enabled.
+	Prev string `json:"prev,omitempty"`
+	Hash string `json:"hash,omitempty"`
+}
+
+// Actor is who caused an event. It is kept in the context of a request so
+// that work the request started in the background is attributed to it.
+type Actor struct {
+	Identity  string
+	ClientIP  string
+	RequestID string
+}
+
+type actorKey struct{}
+
+func WithActor(ctx context.Context, a Actor) context.Context {
+	return context.WithValue(ctx, actorKey{}, a)
+}
+
+// ActorFromContext returns the actor of ctx, or the "system" identity for
+// work that no request started.
+func ActorFromContext(ctx context.Context) Actor {
+	if a, ok := ctx.Value(actorKey{}).(Actor); ok {
+		return a
+	}
+	return Actor{Identity: "system"}
+}
+
+// NewEvent returns an event attributed to the actor of ctx.
+func NewEvent(ctx context.Context, action string) *Event {
+	a := ActorFromContext(ctx)
+	return &Event{
+		Action:    action,
+		Identity:  a.Identity,
+		ClientIP:  a.ClientIP,
+		RequestID: a.RequestID,
+	}
+}
+
+// Logger appends events to the audit log. A nil Logger discards them.
+type Logger struct {
+	mu   sync.Mutex
+	cfg  config.AuditConfig
+	f    *os.File
+	size int64
+	prev string
+}
+
+// Open opens the audit log for appending, continuing the hash chain of
+// the existing file. It returns nil when no path is configured.
+func Open(cfg *config.AuditConfig) (*Logger, error) {
+	if cfg.Path == "" {
+		return nil, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(cfg.Path), 0755); err != nil {
+		return nil, fmt.Errorf("create audit log dir: %w", err)
+	}
+
+	l := &Logger{cfg: *cfg}
+	if cfg.HashChain {
+		prev, err := lastHash(cfg.Path)
+		if err != nil {
+			return nil, fmt.Errorf("read audit log: %w", err)
+		}
+		l.prev = prev
+	}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *Logger) open() error {
+	f, err := os.OpenFile(l.cfg.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return fmt.Errorf("open audit log: %w", err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.f, l.size = f, fi.Size()
+	return nil
+}
+
+// Log appends e, setting its time when unset. Failures are logged; the
+// request that caused the event is not affected.
+func (l *Logger) Log(e *Event) {
+	if l == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	e.Time = e.Time.UTC()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		slog.Error("audit log closed, dropping event", "action", e.Action)
+		return
+	}
+
+	if l.cfg.HashChain {
+		e.Prev = l.prev
+		e.Hash = hashEvent(e)
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		slog.Error("encode audit event", "err", err)
+		return
+	}
+	data = append(data, '\n')
+
+	if l.cfg.MaxSize > 0 && l.size > 0 && l.size+int64(len(data)) > l.cfg.MaxSize {
+		if err := l.rotate(); err != nil {
+			slog.Error("rotate audit log", "err", err)
+			if l.f == nil {
+				return
+			}
+		}
+	}
+	n, err := l.f.Write(data)
+	if err != nil {
+		slog.Error("write audit log", "err", err)
+		// a partial line would break the hash chain for Verify
+		if n > 0 {
+			if err := l.f.Truncate(l.size); err != nil {
+				slog.Error("truncate partial audit event", "err", err)
+				l.size += int64(n)
+			}
+		}
+		return
+	}
+	l.size += int64(n)
+	l.prev = e.Hash
+}
+
+// rotate renames the current file with a timestamp suffix, removes the
+// oldest rotated files beyond MaxBackups and starts a new file.
+func (l *Logger) rotate() error {
+	if err := l.f.Close(); err != nil {
+		slog.Warn("close audit log", "err", err)
+	}
+	l.f = nil
+	backup := l.cfg.Path + "." + time.Now().UTC().Format("20060102T150405.000000000")
+	if err := os.Rename(l.cfg.Path, backup); err != nil {
+		// keep appending to the current file
+		return errors.Join(err, l.open())
+	}
+
+	if l.cfg.MaxBackups > 0 {
+		backups, err := Backups(l.cfg.Path)
+		if err == nil && len(backups) > l.cfg.MaxBackups {
+			for _, old := range backups[:len(backups)-l.cfg.MaxBackups] {
+				if err := os.Remove(old); err != nil {
+					slog.Warn("remove rotated audit log", "path", old, "err", err)
+				}
+			}
+		}
+	}
+	return l.open()
+}
+
+// Close closes the audit log.
+func (l *Logger) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return nil
+	}
+	err := l.f.Close()
+	l.f = nil
+	return err
+}
+
+// Backups returns the rotated files of the audit log at path, oldest
+// first.
+func Backups(path string) ([]string, error) {
+	files, err := filepath.Glob(path + ".*")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// hashEvent returns the hash of e without its Hash field.
+func hashEvent(e *Event) string {
+	c := *e
+	c.Hash = ""
+	data, _ := json.Marshal(&c)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// lastHash returns the hash of the last event at path, or of the newest
+// rotated file when path is empty or missing.
+func lastHash(path string) (string, error) {
+	backups, err := Backups(path)
+	if err != nil {
+		return "", err
+	}
+	files := append(backups, path)
+	for i := len(files) - 1; i >= 0; i-- {
+		line, err := lastLine(files[i])
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if line == nil {
+			continue
+		}
+		var e Event
+		if err := json.Unmarshal(line, &e); err != nil {
+			return "", fmt.Errorf("%s: last event: %w", files[i], err)
+		}
+		return e.Hash, nil
+	}
+	return "", nil
+}
+
+// lastLine returns the last non-empty line of the file at path, reading
+// at most its last 64 KiB.
+func lastLine(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	start := max(fi.Size()-64<<10, 0)
+	buf := make([]byte, fi.Size()-start)
+	if _, err := f.ReadAt(buf, start); err != nil && err != io.EOF {
+		return nil, err
+	}
+	buf = bytes.TrimRight(buf, "\n")
+	if len(buf) == 0 {
+		return nil, nil
+	}
+	return buf[bytes.LastIndexByte(buf, '\n')+1:], nil
+}
+
+// Verify checks the hash chain of the events read from r. prev is the hash
+// the first event must link to, or empty to accept any. It returns the
+// hash of the last event and the number of events.
+func Verify(r io.Reader, prev string) (string, int, error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64<<10), 1<<20)
+	n := 0
+	for sc.Scan() {
+		if len(bytes.TrimSpace(sc.Bytes())) == 0 {
+			continue
+		}
+		n++
+		var e Event
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			return prev, n, fmt.Errorf("event %d: %w", n, err)
+		}
+		if e.Hash == "" {
+			return prev, n, fmt.Errorf("event %d: not hash chained", n)
+		}
+		if (n > 1 || prev != "") && e.Prev != prev {
+			return prev, n, fmt.Errorf("event %d: chain broken, previous hash %s, expected %s", n, e.Prev, prev)
+		}
+		if got := hashEvent(&e); got != e.Hash {
+			return prev, n, fmt.Errorf("event %d: modified, hash %s, expected %s", n, got, e.Hash)
+		}
+		prev = e.Hash
+	}
+	return prev, n, sc.Err()
+}
@@ -0,0 +1,213 @@
+package car
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"runtime"
+	"sync"
+
+	"github.com/filecoin-project/go-commp-utils/v2/writer"
+	"github.com/filecoin-project/go-commp-utils/v2/zerocomm"
+	commcid "github.com/filecoin-project/go-fil-commcid"
+	commphh "github.com/filecoin-project/go-fil-commp-hashhash"
+	"github.com/filecoin-project/go-state-types/abi"
+)
+
+const (
+	// commpSubtreePadded is the padded size of the subtrees hashed
+	// independently by ParallelWriter.
+	commpSubtreePadded = 16 << 20
+	commpSubtree       = commpSubtreePadded / 128 * 127
+
+	// commpMinPayload is the smallest unpadded piece; shorter payloads are
+	// zero filled, which does not change the commitment.
+	commpMinPayload = 127
+)
+
+// subtreeBufs holds subtree buffers for reuse across writers, so that
+// concurrent uploads do not each allocate their own.
+var subtreeBufs = sync.Pool{
+	New: func() any {
+		return make([]byte, commpSubtree)
+	},
+}
+
+type subtreeResult struct {
+	root []byte
+	err  error
+}
+
+// ParallelWriter computes the same commP as writer.Writer. The padded piece
+// is split into 16 MiB subtrees that are hashed on up to workers goroutines,
+// and their roots are combined into the piece root, padding with zero
+// subtrees up to a power of two. A writer holds at most workers+1 subtree
+// buffers.
+type ParallelWriter struct {
+	len    int64
+	buf    []byte
+	roots  []chan subtreeResult
+	sem    chan struct{}
+	closed bool
+}
+
+// NewParallelWriter returns a writer hashing on workers goroutines, or on
+// every CPU if workers is not positive.
+func NewParallelWriter(workers int) *ParallelWriter {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	return &ParallelWriter{
+		sem: make(chan struct{}, workers),
+	}
+}
+
+func (w *ParallelWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errors.New("write after Sum")
+	}
+
+	n := len(p)
+	for len(p) > 0 {
+		if w.buf == nil {
+			w.buf = subtreeBufs.Get().([]byte)[:0]
+		}
+		copied := copy(w.buf[len(w.buf):commpSubtree], p)
+		w.buf = w.buf[:len(w.buf)+copied]
+		p = p[copied:]
+		w.len += int64(copied)
+
+		if len(w.buf) == commpSubtree {
+			w.hashSubtree(w.buf)
+			w.buf = nil
+		}
+	}
+	return n, nil
+}
+
+// hashSubtree hashes a full subtree in the background, waiting for a free
+// worker first so at most cap(sem) buffers are in flight.
+func (w *ParallelWriter) hashSubtree(buf []byte) {
+	res := make(chan subtreeResult, 1)
+	w.roots = append(w.roots, res)
+
+	w.sem <- struct{}{}
+	go func() {
+		defer func() { <-w.sem }()
+		root, _, err := commpOf(buf)
+		subtreeBufs.Put(buf[:cap(buf)])
+		res <- subtreeResult{root: root, err: err}
+	}()
+}
+
+// Sum returns the commitment of everything written. The writer cannot be
+// used afterwards.
+func (w *ParallelWriter) Sum() (writer.DataCIDSize, error) {
+	w.closed = true
+	if w.len == 0 {
+		return writer.DataCIDSize{}, errors.New("no data written")
+	}
+
+	// pieces within a single subtree need no combining
+	if len(w.roots) == 0 {
+		buf := w.buf
+		if len(buf) < commpMinPayload {
+			buf = append(buf, make([]byte, commpMinPayload-len(buf))...)
+		}
+		root, size, err := commpOf(buf)
+		if err != nil {
+			return writer.DataCIDSize{}, err
+		}
+		return w.result(root, size)
+	}
+
+	if w.buf != nil {
+		// zero fill the last subtree
+		tail := w.buf[:commpSubtree]
+		clear(tail[len(w.buf):])
+		w.hashSubtree(tail)
+		w.buf = nil
+	}
+
+	layer := make([][]byte, len(w.roots))
+	for i, res := range w.roots {
+		r := <-res
+		if r.err != nil {
+			return writer.DataCIDSize{}, fmt.Errorf("hash subtree %d: %w", i, r.err)
+		}
+		layer[i] = r.root
+	}
+
+	size := uint64(1<<bits.Len64(uint64(len(layer)-1))) * commpSubtreePadded
+	zero := zeroSubtree(commpSubtreePadded)
+	for len(layer) > 1 {
+		if len(layer)%2 == 1 {
+			layer = append(layer, zero)
+		}
+		next := make([][]byte, len(layer)/2)
+		for i := range next {
+			next[i] = hashNodes(layer[2*i], layer[2*i+1])
+		}
+		layer = next
+		zero = hashNodes(zero, zero)
+	}
+	return w.result(layer[0], size)
+}
+
+func (w *ParallelWriter) result(root []byte, size uint64) (writer.DataCIDSize, error) {
+	c, err := commcid.DataCommitmentV1ToCID(root)
+	if err != nil {
+		return writer.DataCIDSize{}, err
+	}
+	return writer.DataCIDSize{
+		PayloadSize: w.len,
+		PieceSize:   abi.PaddedPieceSize(size),
+		PieceCID:    c,
+	}, nil
+}
+
+// commpOf returns the root and padded size of the piece holding buf.
+func commpOf(buf []byte) ([]byte, uint64, error) {
+	cp := &commphh.Calc{}
+	if _, err := cp.Write(buf); err != nil {
+		return nil, 0, err
+	}
+	return cp.Digest()
+}
+
+// zeroSubtree returns the root of an all-zero subtree of padded size.
+func zeroSubtree(padded uint64) []byte {
+	level := bits.TrailingZeros64(padded) - 5 - zerocomm.Skip
+	root := zerocomm.PieceComms[level]
+	return root[:]
+}
+
+// hashNodes computes a parent node of the piece tree, sha256 truncated to
+// 254 bits.
+func hashNodes(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write(left)
+	h.Write(right)
+	out := h.Sum(nil)
+	out[31] &= 0x3f
+	return out
+}
+
+// ParallelCommpReader is CommpReader hashing on workers goroutines, or on
+// every CPU if workers is not positive.
+func ParallelCommpReader(rdr io.Reader, workers int) (*writer.DataCIDSize, error) {
+	w := NewParallelWriter(workers)
+	_, err := io.CopyBuffer(w, rdr, make([]byte, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("copy into commp writer: %w", err)
+	}
+
+	cp, err := w.Sum()
+	if err != nil {
+		return nil, fmt.Errorf("computing commP failed: %w", err)
+	}
+
+	return &cp, nil
+}
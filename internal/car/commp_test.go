@@ -0,0 +1,91 @@
+package car
+
+import (
+	"io"
+	"testing"
+
+	"github.com/filecoin-project/go-commp-utils/v2/writer"
+)
+
+// writeSeeded writes size bytes of seeded data to w in chunks of chunk
+// bytes, so that writes straddle subtree boundaries.
+func writeSeeded(t testing.TB, w io.Writer, size int64, chunk int) {
+	t.Helper()
+	if _, err := io.CopyBuffer(struct{ io.Writer }{w}, NewSeededReader(size, uint64(size)), make([]byte, chunk)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParallelWriterMatchesWriter(t *testing.T) {
+	sizes := []int64{
+		1,
+		commpMinPayload - 1,
+		commpMinPayload,
+		commpMinPayload + 1,
+		1 << 20,
+		commpSubtree - 1,
+		commpSubtree,
+		commpSubtree + 1,
+	}
+	if !testing.Short() {
+		sizes = append(sizes,
+			2*commpSubtree-1,
+			2*commpSubtree,
+			3*commpSubtree+commpMinPayload,
+			4*commpSubtree+1,
+		)
+	}
+
+	for _, size := range sizes {
+		var want writer.Writer
+		writeSeeded(t, &want, size, 1<<20)
+		wantSum, err := want.Sum()
+		if err != nil {
+			t.Fatalf("size %d: writer: %v", size, err)
+		}
+
+		for _, workers := range []int{1, 3} {
+			got := NewParallelWriter(workers)
+			writeSeeded(t, got, size, 1<<20+3)
+			gotSum, err := got.Sum()
+			if err != nil {
+				t.Fatalf("size %d, %d workers: %v", size, workers, err)
+			}
+			if !gotSum.PieceCID.Equals(wantSum.PieceCID) || gotSum.PieceSize != wantSum.PieceSize || gotSum.PayloadSize != wantSum.PayloadSize {
+				t.Errorf("size %d, %d workers: got %s/%d/%d, want %s/%d/%d", size, workers,
+					gotSum.PieceCID, gotSum.PieceSize, gotSum.PayloadSize,
+					wantSum.PieceCID, wantSum.PieceSize, wantSum.PayloadSize)
+			}
+		}
+	}
+}
+
+func TestParallelWriterEmpty(t *testing.T) {
+	if _, err := NewParallelWriter(1).Sum(); err == nil {
+		t.Fatal("expected an error for an empty piece")
+	}
+}
+
+const benchmarkSize = 4 * commpSubtree
+
+func BenchmarkWriter(b *testing.B) {
+	b.SetBytes(benchmarkSize)
+	for range b.N {
+		var w writer.Writer
+		writeSeeded(b, &w, benchmarkSize, 1<<20)
+		if _, err := w.Sum(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkParallelWriter(b *testing.B) {
+	b.SetBytes(benchmarkSize)
+	for range b.N {
+		w := NewParallelWriter(0)
+		writeSeeded(b, w, benchmarkSize, 1<<20)
+		if _, err := w.Sum(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,431 @@
+package car
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+
+	"github.com/ipfs/go-cid"
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/ipld/go-car/v2/storage"
+	"github.com/multiformats/go-multihash"
+	"github.com/multiformats/go-varint"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	// ModeRaw writes the payload as flat raw blocks, rooted at the first.
+	ModeRaw = "raw"
+	// ModeUnixFS writes UnixFS files with balanced DAGs of raw leaves,
+	// grouped under directories when there is more than one file.
+	ModeUnixFS = "unixfs"
+
+	DefaultBlockSize = 1 << 20
+	DefaultFanout    = 174
+
+	maxBlockSize = 4 << 20
+	// maxBlocks bounds the leaves of a CAR, and with them the CIDs the
+	// CAR writer and NewPlan keep to skip duplicate blocks.
+	maxBlocks = 1 << 20
+	// uniqueBlockSize is the smallest block size at which random blocks
+	// are assumed never to repeat.
+	uniqueBlockSize = 32
+)
+
+// GenerateOptions describes a generated CAR. The same options always
+// produce the same CAR.
+type GenerateOptions struct {
+	// Size is the payload size, the total of all file data.
+	Size int64
+	Seed uint64
+	Mode string
+	// BlockSize is the size of the raw leaf blocks.
+	BlockSize int64
+	// Fanout is the maximum number of links of file and directory nodes.
+	Fanout int
+	// Files is the number of files in unixfs mode.
+	Files int
+}
+
+// Validate fills in defaults and checks the options.
+func (o *GenerateOptions) Validate() error {
+	if o.Mode == "" {
+		o.Mode = ModeRaw
+	}
+	if o.BlockSize == 0 {
+		o.BlockSize = DefaultBlockSize
+	}
+	if o.Fanout == 0 {
+		o.Fanout = DefaultFanout
+	}
+	if o.Files == 0 {
+		o.Files = 1
+	}
+
+	switch {
+	case o.Mode != ModeRaw && o.Mode != ModeUnixFS:
+		return fmt.Errorf("unknown mode %q", o.Mode)
+	case o.Size <= 0:
+		return errors.New("size must be positive")
+	case o.BlockSize < 1 || o.BlockSize > maxBlockSize:
+		return fmt.Errorf("block size must be between 1 and %d", maxBlockSize)
+	case (o.Size-1)/o.BlockSize >= maxBlocks:
+		return fmt.Errorf("size needs more than %d blocks, raise the block size", maxBlocks)
+	case o.Fanout < 2:
+		return errors.New("fanout must be at least 2")
+	case o.Files < 1 || int64(o.Files) > o.Size:
+		return errors.New("files must be between 1 and size")
+	case o.Files > maxBlocks:
+		return fmt.Errorf("files must be at most %d", maxBlocks)
+	case o.Mode == ModeRaw && o.Files != 1:
+		return errors.New("files requires unixfs mode")
+	}
+	return nil
+}
+
+// RandomSeed returns a seed that survives a round trip through JSON numbers.
+func RandomSeed() uint64 {
+	return rand.Uint64N(1 << 53)
+}
+
+// NewSeededReader returns size bytes of deterministic pseudo-random data.
+func NewSeededReader(size int64, seed uint64) io.Reader {
+	var s [32]byte
+	binary.LittleEndian.PutUint64(s[:], seed)
+	return io.LimitReader(rand.NewChaCha8(s), size)
+}
+
+// WriteCar writes the CARv1 described by opts to w and returns its root.
+// The root goes into the header, so unixfs DAGs are hashed once up front
+// and generated again while writing.
+func WriteCar(ctx context.Context, w io.Writer, opts GenerateOptions) (cid.Cid, error) {
+	if err := opts.Validate(); err != nil {
+		return cid.Undef, err
+	}
+
+	root, err := newGenerator(opts, nil).run()
+	if err != nil {
+		return cid.Undef, err
+	}
+	return root, writeCar(ctx, w, opts, root)
+}
+
+// Plan is a generated CAR whose root and size are known before it is
+// written.
+type Plan struct {
+	Options GenerateOptions
+	Root    cid.Cid
+	// Size is the exact length of the CAR.
+	Size int64
+}
+
+// NewPlan finds the root and size of the CAR described by opts, so that
+// it can be streamed to writers that need the length up front. The size of
+// raw CARs is computed from the options; small raw blocks, which may
+// repeat, and unixfs DAGs are hashed in full.
+func NewPlan(ctx context.Context, opts GenerateOptions) (*Plan, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	var root cid.Cid
+	var size int64
+	var err error
+	if opts.Mode == ModeRaw && opts.BlockSize >= uniqueBlockSize {
+		root, err = newGenerator(opts, nil).run()
+		if err != nil {
+			return nil, err
+		}
+		// every raw CID has the length of the root
+		full, rest := opts.Size/opts.BlockSize, opts.Size%opts.BlockSize
+		size = full * sectionSize(root.ByteLen(), opts.BlockSize)
+		if rest > 0 {
+			size += sectionSize(root.ByteLen(), rest)
+		}
+	} else {
+		// count sections the way the CAR writer does, skipping duplicates
+		seen := make(map[string]struct{})
+		emit := func(c cid.Cid, data []byte) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			key := string(c.Hash())
+			if _, ok := seen[key]; ok {
+				return nil
+			}
+			seen[key] = struct{}{}
+			size += sectionSize(c.ByteLen(), int64(len(data)))
+			return nil
+		}
+		root, err = newGenerator(opts, emit).run()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var header countingWriter
+	cw, err := storage.NewWritable(&header, []cid.Cid{root}, carv2.WriteAsCarV1(true))
+	if err != nil {
+		return nil, err
+	}
+	if err := cw.Finalize(); err != nil {
+		return nil, err
+	}
+
+	return &Plan{Options: opts, Root: root, Size: int64(header) + size}, nil
+}
+
+// WriteTo writes the planned CAR to w.
+func (p *Plan) WriteTo(ctx context.Context, w io.Writer) error {
+	return writeCar(ctx, w, p.Options, p.Root)
+}
+
+func writeCar(ctx context.Context, w io.Writer, opts GenerateOptions, root cid.Cid) error {
+	cw, err := storage.NewWritable(w, []cid.Cid{root}, carv2.WriteAsCarV1(true))
+	if err != nil {
+		return fmt.Errorf("failed to create car writer: %w", err)
+	}
+	emit := func(c cid.Cid, data []byte) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return cw.Put(ctx, c.KeyString(), data)
+	}
+	if _, err := newGenerator(opts, emit).run(); err != nil {
+		return err
+	}
+	return cw.Finalize()
+}
+
+// sectionSize returns the length of a CAR section holding a block.
+func sectionSize(cidLen int, dataLen int64) int64 {
+	n := uint64(cidLen) + uint64(dataLen)
+	return int64(varint.UvarintSize(n)) + int64(n)
+}
+
+type countingWriter int64
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	*w += countingWriter(len(p))
+	return len(p), nil
+}
+
+type link struct {
+	cid   cid.Cid
+	name  string
+	tsize uint64 // bytes of all blocks under the link
+	fsize uint64 // file bytes under the link
+}
+
+type generator struct {
+	opts GenerateOptions
+	rdr  io.Reader
+	buf  []byte
+	emit func(c cid.Cid, data []byte) error
+}
+
+// newGenerator returns a generator passing blocks to emit. With a nil emit
+// only the root is computed; raw mode then stops after the first block.
+func newGenerator(opts GenerateOptions, emit func(c cid.Cid, data []byte) error) *generator {
+	return &generator{
+		opts: opts,
+		rdr:  NewSeededReader(opts.Size, opts.Seed),
+		buf:  make([]byte, opts.BlockSize),
+		emit: emit,
+	}
+}
+
+func (g *generator) run() (cid.Cid, error) {
+	if g.opts.Mode == ModeRaw {
+		return g.raw()
+	}
+
+	if g.opts.Files == 1 {
+		l, err := g.file(g.opts.Size)
+		return l.cid, err
+	}
+
+	entries := make([]link, g.opts.Files)
+	per, extra := g.opts.Size/int64(g.opts.Files), g.opts.Size%int64(g.opts.Files)
+	for i := range entries {
+		size := per
+		if int64(i) < extra {
+			size++
+		}
+		l, err := g.file(size)
+		if err != nil {
+			return cid.Undef, err
+		}
+		l.name = fmt.Sprintf("file-%06d.bin", i)
+		entries[i] = l
+	}
+
+	for len(entries) > g.opts.Fanout {
+		var dirs []link
+		for i := 0; i < len(entries); i += g.opts.Fanout {
+			l, err := g.directory(entries[i:min(i+g.opts.Fanout, len(entries))])
+			if err != nil {
+				return cid.Undef, err
+			}
+			l.name = fmt.Sprintf("dir-%06d", len(dirs))
+			dirs = append(dirs, l)
+		}
+		entries = dirs
+	}
+	l, err := g.directory(entries)
+	return l.cid, err
+}
+
+// raw emits the payload as raw blocks and returns the first.
+func (g *generator) raw() (cid.Cid, error) {
+	var root cid.Cid
+	for {
+		l, err := g.leaf(g.opts.BlockSize)
+		if errors.Is(err, io.EOF) {
+			return root, nil
+		}
+		if err != nil {
+			return cid.Undef, err
+		}
+		if !root.Defined() {
+			root = l.cid
+			if g.emit == nil {
+				return root, nil
+			}
+		}
+	}
+}
+
+// leaf reads up to n bytes into a raw block.
+func (g *generator) leaf(n int64) (link, error) {
+	data := g.buf[:min(n, g.opts.BlockSize)]
+	read, err := io.ReadFull(g.rdr, data)
+	if read == 0 && n > 0 {
+		return link{}, io.EOF
+	}
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return link{}, err
+	}
+	data = data[:read]
+
+	c, err := sumCid(cid.Raw, data)
+	if err != nil {
+		return link{}, err
+	}
+	if g.emit != nil {
+		if err := g.emit(c, data); err != nil {
+			return link{}, err
+		}
+	}
+	return link{cid: c, tsize: uint64(read), fsize: uint64(read)}, nil
+}
+
+// file emits a balanced file DAG of size bytes. Files of a single block
+// are the raw leaf itself.
+func (g *generator) file(size int64) (link, error) {
+	var level []link
+	for remain := size; remain > 0 || len(level) == 0; {
+		l, err := g.leaf(remain)
+		if err != nil {
+			return link{}, err
+		}
+		remain -= int64(l.fsize)
+		level = append(level, l)
+	}
+
+	for len(level) > 1 {
+		var next []link
+		for i := 0; i < len(level); i += g.opts.Fanout {
+			children := level[i:min(i+g.opts.Fanout, len(level))]
+			var fsize uint64
+			blocksizes := make([]uint64, len(children))
+			for j, child := range children {
+				blocksizes[j] = child.fsize
+				fsize += child.fsize
+			}
+			l, err := g.node(children, unixfsData(unixfsFile, fsize, blocksizes))
+			if err != nil {
+				return link{}, err
+			}
+			l.fsize = fsize
+			next = append(next, l)
+		}
+		level = next
+	}
+	return level[0], nil
+}
+
+func (g *generator) directory(entries []link) (link, error) {
+	return g.node(entries, unixfsData(unixfsDirectory, 0, nil))
+}
+
+// node emits a dag-pb node linking to children.
+func (g *generator) node(children []link, data []byte) (link, error) {
+	block := encodePBNode(children, data)
+	c, err := sumCid(cid.DagProtobuf, block)
+	if err != nil {
+		return link{}, err
+	}
+	if g.emit != nil {
+		if err := g.emit(c, block); err != nil {
+			return link{}, err
+		}
+	}
+
+	tsize := uint64(len(block))
+	for _, child := range children {
+		tsize += child.tsize
+	}
+	return link{cid: c, tsize: tsize}, nil
+}
+
+func sumCid(codec uint64, data []byte) (cid.Cid, error) {
+	mh, err := multihash.Sum(data, multihash.SHA2_256, -1)
+	if err != nil {
+		return cid.Undef, err
+	}
+	return cid.NewCidV1(codec, mh), nil
+}
+
+// UnixFS data types, from the unixfs protobuf.
+const (
+	unixfsDirectory = 1
+	unixfsFile      = 2
+)
+
+// unixfsData encodes the unixfs Data message of a node.
+func unixfsData(typ, filesize uint64, blocksizes []uint64) []byte {
+	b := protowire.AppendTag(nil, 1, protowire.VarintType)
+	b = protowire.AppendVarint(b, typ)
+	if typ == unixfsFile {
+		b = protowire.AppendTag(b, 3, protowire.VarintType)
+		b = protowire.AppendVarint(b, filesize)
+		for _, size := range blocksizes {
+			b = protowire.AppendTag(b, 4, protowire.VarintType)
+			b = protowire.AppendVarint(b, size)
+		}
+	}
+	return b
+}
+
+// encodePBNode encodes a dag-pb node in canonical form, links before data.
+func encodePBNode(links []link, data []byte) []byte {
+	var b []byte
+	for _, l := range links {
+		var pl []byte
+		pl = protowire.AppendTag(pl, 1, protowire.BytesType)
+		pl = protowire.AppendBytes(pl, l.cid.Bytes())
+		pl = protowire.AppendTag(pl, 2, protowire.BytesType)
+		pl = protowire.AppendString(pl, l.name)
+		pl = protowire.AppendTag(pl, 3, protowire.VarintType)
+		pl = protowire.AppendVarint(pl, l.tsize)
+
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendBytes(b, pl)
+	}
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	return protowire.AppendBytes(b, data)
+}
@@ -0,0 +1,30 @@
+package car
+
+import (
+	carv2 "github.com/ipld/go-car/v2"
+)
+
+// Info describes a CAR file.
+type Info struct {
+	carv2.Stats
+	HasIndex bool
+}
+
+// Inspect scans a CARv1 or CARv2 file. With verify every block is hashed
+// and compared to its CID, and the first mismatch is returned as an error.
+func Inspect(inPath string, verify bool) (*Info, error) {
+	rdr, err := carv2.OpenReader(inPath)
+	if err != nil {
+		return nil, err
+	}
+	defer rdr.Close()
+
+	stats, err := rdr.Inspect(verify)
+	if err != nil {
+		return nil, err
+	}
+	return &Info{
+		Stats:    stats,
+		HasIndex: rdr.Version == 2 && rdr.Header.HasIndex(),
+	}, nil
+}
@@ -0,0 +1,47 @@
+package car
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+
+	"github.com/filecoin-project/go-commp-utils/v2/writer"
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+)
+
+// mhFr32Sha256Trunc254Padbintree is the multihash of v2 piece CIDs (FRC-0069).
+const mhFr32Sha256Trunc254Padbintree = 0x1011
+
+// PieceCIDV2 returns the v2 piece CID, which unlike the v1 CID also encodes
+// the tree height and the padding after the payload.
+func PieceCIDV2(cp *writer.DataCIDSize) (cid.Cid, error) {
+	dmh, err := multihash.Decode(cp.PieceCID.Hash())
+	if err != nil {
+		return cid.Undef, fmt.Errorf("decode piece cid: %w", err)
+	}
+	if len(dmh.Digest) != 32 {
+		return cid.Undef, fmt.Errorf("unexpected commP digest length %d", len(dmh.Digest))
+	}
+
+	padded := uint64(cp.PieceSize)
+	if padded < 128 || bits.OnesCount64(padded) != 1 {
+		return cid.Undef, fmt.Errorf("invalid piece size %d", padded)
+	}
+	unpadded := padded - padded/128
+	payload := uint64(cp.PayloadSize)
+	if payload > unpadded {
+		return cid.Undef, fmt.Errorf("payload size %d exceeds piece size %d", cp.PayloadSize, padded)
+	}
+	height := bits.TrailingZeros64(padded) - 5
+
+	digest := binary.AppendUvarint(nil, unpadded-payload)
+	digest = append(digest, byte(height))
+	digest = append(digest, dmh.Digest...)
+
+	mh, err := multihash.Encode(digest, mhFr32Sha256Trunc254Padbintree)
+	if err != nil {
+		return cid.Undef, err
+	}
+	return cid.NewCidV1(cid.Raw, mh), nil
+}
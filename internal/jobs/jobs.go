@@ -0,0 +1,408 @@
+// Package jobs runs long operations in the background, outside the HTTP
+// request that started them. Jobs are queued per type, report byte
+// progress, can be cancelled and are kept on disk across restarts.
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/web3tea/piecehub/config"
+)
+
+type State string
+
+const (
+	Queued   State = "queued"
+	Running  State = "running"
+	Done     State = "done"
+	Failed   State = "failed"
+	Canceled State = "canceled"
+)
+
+// Finished reports whether a job in state s has ended.
+func (s State) Finished() bool {
+	return s == Done || s == Failed || s == Canceled
+}
+
+var (
+	ErrNotFound = errors.New("job not found")
+	ErrFinished = errors.New("job already finished")
+	ErrClosed   = errors.New("job manager closed")
+	ErrCanceled = errors.New("job canceled")
+
+	errShutdown = errors.New("interrupted by shutdown")
+)
+
+// Job is a snapshot of a job, as served by the API and stored on disk.
+type Job struct {
+	ID     string          `json:"id"`
+	Type   string          `json:"type"`
+	State  State           `json:"state"`
+	Params json.RawMessage `json:"params,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+	// Bytes of Total have been processed. ETASeconds estimates the time
+	// left from the rate since Total was set.
+	Bytes      int64      `json:"bytes"`
+	Total      int64      `json:"total,omitempty"`
+	ETASeconds int64      `json:"etaSeconds,omitempty"`
+	Created    time.Time  `json:"created"`
+	Started    *time.Time `json:"started,omitempty"`
+	Finished   *time.Time `json:"finished,omitempty"`
+}
+
+// Func does the work of a job. It should return soon after ctx is done.
+type Func func(ctx context.Context, p *Progress) (any, error)
+
+type entry struct {
+	job      Job
+	fn       Func
+	ctx      context.Context
+	cancel   context.CancelCauseFunc
+	progress Progress
+	err      error
+	done     chan struct{}
+}
+
+// Manager schedules jobs, running at most the configured number of each
+// type at once.
+type Manager struct {
+	mu      sync.Mutex
+	cfg     config.JobsConfig
+	dir     string
+	jobs    map[string]*entry
+	queues  map[string][]*entry
+	running map[string]int
+	closed  bool
+	wg      sync.WaitGroup
+}
+
+// NewManager loads the jobs kept in cfg.StateDir. Jobs that were queued or
+// running when the previous process stopped are marked failed.
+func NewManager(cfg *config.JobsConfig) (*Manager, error) {
+	m := &Manager{
+		cfg:     *cfg,
+		dir:     cfg.StateDir,
+		jobs:    make(map[string]*entry),
+		queues:  make(map[string][]*entry),
+		running: make(map[string]int),
+	}
+	if m.dir == "" {
+		return m, nil
+	}
+
+	if err := os.MkdirAll(m.dir, 0755); err != nil {
+		return nil, fmt.Errorf("create job state dir: %w", err)
+	}
+	files, err := filepath.Glob(filepath.Join(m.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range files {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		e := &entry{done: make(chan struct{})}
+		if err := json.Unmarshal(data, &e.job); err != nil {
+			slog.Warn("skipping unreadable job", "path", path, "err", err)
+			continue
+		}
+		close(e.done)
+		if !e.job.State.Finished() {
+			m.finish(e, Failed, "interrupted by restart")
+		}
+		m.jobs[e.job.ID] = e
+	}
+	m.prune()
+	return m, nil
+}
+
+// Update applies new limits and retention. The state dir is only read at
+// start.
+func (m *Manager) Update(cfg *config.JobsConfig) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cfg = *cfg
+	for typ := range m.queues {
+		m.schedule(typ)
+	}
+}
+
+// Submit queues fn as a job of type typ. params are recorded with the job.
+// The job is cancelled when ctx is done, so callers that return before the
+// job finishes should pass a context that outlives them.
+func (m *Manager) Submit(ctx context.Context, typ string, params any, fn Func) (Job, error) {
+	raw, err := json.Marshal(params)
+	if err != nil {
+		return Job{}, err
+	}
+
+	var b [8]byte
+	rand.Read(b[:])
+	e := &entry{
+		job: Job{
+			ID:      hex.EncodeToString(b[:]),
+			Type:    typ,
+			State:   Queued,
+			Params:  raw,
+			Created: time.Now(),
+		},
+		fn:   fn,
+		done: make(chan struct{}),
+	}
+	e.ctx, e.cancel = context.WithCancelCause(ctx)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		e.cancel(ErrClosed)
+		return Job{}, ErrClosed
+	}
+	m.prune()
+	m.jobs[e.job.ID] = e
+	m.queues[typ] = append(m.queues[typ], e)
+	m.save(e)
+	m.schedule(typ)
+	return e.snapshot(), nil
+}
+
+// schedule starts queued jobs of typ while below its limit.
+func (m *Manager) schedule(typ string) {
+	limit, ok := m.cfg.Concurrency[typ]
+	if !ok {
+		limit = m.cfg.DefaultConcurrency
+	}
+	for len(m.queues[typ]) > 0 && (limit == 0 || m.running[typ] < limit) {
+		e := m.queues[typ][0]
+		m.queues[typ] = m.queues[typ][1:]
+
+		now := time.Now()
+		e.job.State = Running
+		e.job.Started = &now
+		m.running[typ]++
+		m.save(e)
+		m.wg.Add(1)
+		go m.run(e)
+	}
+}
+
+func (m *Manager) run(e *entry) {
+	defer m.wg.Done()
+	result, err := e.fn(e.ctx, &e.progress)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.running[e.job.Type]--
+	defer m.schedule(e.job.Type)
+	if e.job.State.Finished() {
+		// already recorded by Shutdown
+		return
+	}
+
+	e.err = err
+	switch cause := context.Cause(e.ctx); {
+	case err == nil:
+		raw, merr := json.Marshal(result)
+		if merr != nil {
+			e.err = merr
+			m.finish(e, Failed, merr.Error())
+			return
+		}
+		e.job.Result = raw
+		m.finish(e, Done, "")
+	case errors.Is(cause, ErrCanceled) || errors.Is(cause, context.Canceled):
+		m.finish(e, Canceled, "")
+	default:
+		m.finish(e, Failed, err.Error())
+	}
+}
+
+// finish records the final state of e.
+func (m *Manager) finish(e *entry, state State, msg string) {
+	now := time.Now()
+	e.job.Bytes, e.job.Total = e.progress.load(e.job.Bytes, e.job.Total)
+	e.job.State = state
+	e.job.Error = msg
+	e.job.Finished = &now
+	m.save(e)
+	select {
+	case <-e.done:
+	default:
+		close(e.done)
+	}
+}
+
+// Get returns the job with id.
+func (m *Manager) Get(id string) (Job, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return e.snapshot(), true
+}
+
+// List returns the jobs of type typ, or all jobs if typ is empty, oldest
+// first.
+func (m *Manager) List(typ string) []Job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.prune()
+
+	jobs := []Job{}
+	for _, e := range m.jobs {
+		if typ == "" || e.job.Type == typ {
+			jobs = append(jobs, e.snapshot())
+		}
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].Created.Before(jobs[j].Created)
+	})
+	return jobs
+}
+
+// Wait blocks until the job has finished or ctx is done and returns the
+// job with the error of its Func, or ErrCanceled.
+func (m *Manager) Wait(ctx context.Context, id string) (Job, error) {
+	m.mu.Lock()
+	e, ok := m.jobs[id]
+	m.mu.Unlock()
+	if !ok {
+		return Job{}, ErrNotFound
+	}
+
+	select {
+	case <-e.done:
+	case <-ctx.Done():
+		return Job{}, ctx.Err()
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch {
+	case e.err != nil:
+		return e.snapshot(), e.err
+	case e.job.State == Canceled:
+		return e.snapshot(), ErrCanceled
+	case e.job.State != Done:
+		return e.snapshot(), errors.New(e.job.Error)
+	}
+	return e.snapshot(), nil
+}
+
+// Cancel stops a queued or running job. Running jobs are marked canceled
+// once their Func returns.
+func (m *Manager) Cancel(id string) (Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.jobs[id]
+	if !ok {
+		return Job{}, ErrNotFound
+	}
+	if e.job.State.Finished() {
+		return e.snapshot(), ErrFinished
+	}
+
+	e.cancel(ErrCanceled)
+	if e.job.State == Queued {
+		q := m.queues[e.job.Type]
+		for i, qe := range q {
+			if qe == e {
+				m.queues[e.job.Type] = append(q[:i:i], q[i+1:]...)
+				break
+			}
+		}
+		m.finish(e, Canceled, "")
+	}
+	return e.snapshot(), nil
+}
+
+// Shutdown cancels all unfinished jobs, records them as failed and waits
+// for running jobs to return until ctx is done.
+func (m *Manager) Shutdown(ctx context.Context) error {
+	m.mu.Lock()
+	m.closed = true
+	for _, e := range m.jobs {
+		if e.job.State.Finished() {
+			continue
+		}
+		e.cancel(errShutdown)
+		e.err = errShutdown
+		m.finish(e, Failed, errShutdown.Error())
+	}
+	clear(m.queues)
+	m.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// prune forgets jobs finished longer than the retention ago.
+func (m *Manager) prune() {
+	cutoff := time.Now().Add(-time.Duration(m.cfg.Retention) * time.Second)
+	for id, e := range m.jobs {
+		if e.job.Finished == nil || e.job.Finished.After(cutoff) {
+			continue
+		}
+		delete(m.jobs, id)
+		if m.dir != "" {
+			if err := os.Remove(m.path(id)); err != nil && !os.IsNotExist(err) {
+				slog.Warn("remove job", "job", id, "err", err)
+			}
+		}
+	}
+}
+
+// save writes the job to the state dir. Failures are logged; the job keeps
+// running from memory.
+func (m *Manager) save(e *entry) {
+	if m.dir == "" {
+		return
+	}
+	data, err := json.Marshal(e.snapshot())
+	if err == nil {
+		tmp := m.path(e.job.ID) + ".tmp"
+		if err = os.WriteFile(tmp, data, 0644); err == nil {
+			err = os.Rename(tmp, m.path(e.job.ID))
+		}
+	}
+	if err != nil {
+		slog.Error("save job", "job", e.job.ID, "err", err)
+	}
+}
+
+func (m *Manager) path(id string) string {
+	return filepath.Join(m.dir, id+".json")
+}
+
+func (e *entry) snapshot() Job {
+	j := e.job
+	if j.State != Running {
+		return j
+	}
+	j.Bytes, j.Total = e.progress.load(j.Bytes, j.Total)
+	j.ETASeconds = e.progress.eta()
+	return j
+}
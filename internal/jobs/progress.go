@@ -0,0 +1,61 @@
+package jobs
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Progress counts the bytes processed by a job. It is an io.Writer so it
+// can be teed into a stream.
+type Progress struct {
+	bytes atomic.Int64
+	total atomic.Int64
+
+	mu    sync.Mutex
+	since time.Time
+}
+
+// SetTotal sets the expected number of bytes and restarts the rate
+// measurement used for the ETA.
+func (p *Progress) SetTotal(n int64) {
+	p.mu.Lock()
+	p.since = time.Now()
+	p.mu.Unlock()
+	p.bytes.Store(0)
+	p.total.Store(n)
+}
+
+func (p *Progress) Add(n int64) {
+	p.bytes.Add(n)
+}
+
+func (p *Progress) Write(b []byte) (int, error) {
+	p.bytes.Add(int64(len(b)))
+	return len(b), nil
+}
+
+// load returns the counters, or bytes and total if nothing was counted,
+// as for jobs loaded from disk.
+func (p *Progress) load(bytes, total int64) (int64, int64) {
+	b, t := p.bytes.Load(), p.total.Load()
+	if b == 0 && t == 0 {
+		return bytes, total
+	}
+	return b, t
+}
+
+// eta returns the estimated seconds left, or 0 when unknown.
+func (p *Progress) eta() int64 {
+	p.mu.Lock()
+	since := p.since
+	p.mu.Unlock()
+
+	b, t := p.bytes.Load(), p.total.Load()
+	elapsed := time.Since(since)
+	if since.IsZero() || b <= 0 || t <= b || elapsed <= 0 {
+		return 0
+	}
+	left := time.Duration(float64(elapsed) / float64(b) * float64(t-b))
+	return int64(left.Round(time.Second) / time.Second)
+}
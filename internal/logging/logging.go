@@ -0,0 +1,105 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Setup installs the default slog logger writing to stderr in the given
+// format ("text" or "json") at the given level.
+func Setup(level, format string) error {
+	logger, err := New(os.Stderr, level, format)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(logger)
+	return nil
+}
+
+func New(w io.Writer, level, format string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q", level)
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+	switch strings.ToLower(format) {
+	case "", "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("invalid log format %q", format)
+	}
+}
+
+type requestIDKey struct{}
+
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+func NewRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// FromContext returns the default logger annotated with the request ID
+// carried by ctx, if any.
+func FromContext(ctx context.Context) *slog.Logger {
+	if id := RequestID(ctx); id != "" {
+		return slog.Default().With("request_id", id)
+	}
+	return slog.Default()
+}
+
+// Fields collects attributes about a request from the layers that handle it,
+// such as the piece id or the storage backend, for the access log.
+type Fields struct {
+	mu    sync.Mutex
+	attrs []any
+}
+
+type fieldsKey struct{}
+
+func WithFields(ctx context.Context) (context.Context, *Fields) {
+	f := &Fields{}
+	return context.WithValue(ctx, fieldsKey{}, f), f
+}
+
+// AddFields records key-value pairs on the request carried by ctx. It is a
+// no-op outside a request.
+func AddFields(ctx context.Context, args ...any) {
+	f, ok := ctx.Value(fieldsKey{}).(*Fields)
+	if !ok || f == nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.attrs = append(f.attrs, args...)
+}
+
+// WithoutFields returns ctx with AddFields disabled, for work on many items
+// within one request that should not all end up in its access log.
+func WithoutFields(ctx context.Context) context.Context {
+	return context.WithValue(ctx, fieldsKey{}, (*Fields)(nil))
+}
+
+func (f *Fields) Args() []any {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]any(nil), f.attrs...)
+}
@@ -0,0 +1,199 @@
+// Package meta stores metadata attached to pieces, such as the deals they
+// belong to and when they expire. Metadata is held in memory and written
+// through to one JSON file per piece.
+package meta
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Meta describes a piece.
+type Meta struct {
+	PayloadCID  string            `json:"payloadCid,omitempty"`
+	PayloadSize int64             `json:"payloadSize,omitempty"`
+	DealIDs     []uint64          `json:"dealIds,omitempty"`
+	Client      string            `json:"client,omitempty"`
+	SourceURL   string            `json:"sourceUrl,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Created     time.Time         `json:"created"`
+	// Expiration is when the piece is no longer needed, as a time or as a
+	// Filecoin chain epoch.
+	Expiration      *time.Time `json:"expiration,omitempty"`
+	ExpirationEpoch int64      `json:"expirationEpoch,omitempty"`
+}
+
+// Filter selects pieces by metadata. Empty fields match everything.
+type Filter struct {
+	// Labels must all be present with the same values.
+	Labels     map[string]string
+	Client     string
+	DealID     uint64
+	PayloadCID string
+	// ExpiresBefore matches pieces whose Expiration is before it.
+	ExpiresBefore time.Time
+}
+
+// Empty reports whether f matches pieces without metadata too.
+func (f *Filter) Empty() bool {
+	return len(f.Labels) == 0 && f.Client == "" && f.DealID == 0 &&
+		f.PayloadCID == "" && f.ExpiresBefore.IsZero()
+}
+
+// Match reports whether m is selected by f.
+func (f *Filter) Match(m *Meta) bool {
+	for k, v := range f.Labels {
+		if got, ok := m.Labels[k]; !ok || got != v {
+			return false
+		}
+	}
+	switch {
+	case f.Client != "" && m.Client != f.Client:
+		return false
+	case f.DealID != 0 && !slices.Contains(m.DealIDs, f.DealID):
+		return false
+	case f.PayloadCID != "" && m.PayloadCID != f.PayloadCID:
+		return false
+	case !f.ExpiresBefore.IsZero() && (m.Expiration == nil || !m.Expiration.Before(f.ExpiresBefore)):
+		return false
+	}
+	return true
+}
+
+// Store holds the metadata of all pieces.
+type Store struct {
+	mu     sync.RWMutex
+	dir    string
+	pieces map[string]*Meta
+}
+
+// Open loads the metadata kept in dir. With an empty dir metadata is only
+// kept in memory.
+func Open(dir string) (*Store, error) {
+	s := &Store{dir: dir, pieces: make(map[string]*Meta)}
+	if dir == "" {
+		return s, nil
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("create metadata dir: %w", err)
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range files {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		m := &Meta{}
+		if err := json.Unmarshal(data, m); err != nil {
+			slog.Warn("skipping unreadable piece metadata", "path", path, "err", err)
+			continue
+		}
+		s.pieces[strings.TrimSuffix(filepath.Base(path), ".json")] = m
+	}
+	return s, nil
+}
+
+// Get returns a copy of the metadata of piece.
+func (s *Store) Get(piece string) (Meta, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	m, ok := s.pieces[piece]
+	if !ok {
+		return Meta{}, false
+	}
+	return m.clone(), true
+}
+
+// Put replaces the metadata of piece. Created is set by the store, to now
+// for new entries, and the value in m is ignored.
+func (s *Store) Put(piece string, m Meta) (Meta, error) {
+	if !validName(piece) {
+		return Meta{}, fmt.Errorf("invalid piece name %q", piece)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m.Created = time.Now().UTC()
+	if old, ok := s.pieces[piece]; ok {
+		m.Created = old.Created
+	}
+	m = m.clone()
+
+	if s.dir != "" {
+		data, err := json.Marshal(&m)
+		if err != nil {
+			return Meta{}, err
+		}
+		tmp := s.path(piece) + ".tmp"
+		if err := os.WriteFile(tmp, data, 0644); err != nil {
+			return Meta{}, err
+		}
+		if err := os.Rename(tmp, s.path(piece)); err != nil {
+			return Meta{}, err
+		}
+	}
+	s.pieces[piece] = &m
+	return m.clone(), nil
+}
+
+// Delete removes the metadata of piece, if any.
+func (s *Store) Delete(piece string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.pieces[piece]; !ok {
+		return nil
+	}
+	if s.dir != "" {
+		if err := os.Remove(s.path(piece)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	delete(s.pieces, piece)
+	return nil
+}
+
+// Find returns the metadata of all pieces matching f, by piece.
+func (s *Store) Find(f *Filter) map[string]Meta {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	found := make(map[string]Meta)
+	for piece, m := range s.pieces {
+		if f.Match(m) {
+			found[piece] = m.clone()
+		}
+	}
+	return found
+}
+
+func (s *Store) path(piece string) string {
+	return filepath.Join(s.dir, piece+".json")
+}
+
+// validName rejects names that would escape the metadata dir.
+func validName(piece string) bool {
+	return piece != "" && !strings.ContainsAny(piece, `/\`) && !strings.HasPrefix(piece, ".")
+}
+
+func (m *Meta) clone() Meta {
+	c := *m
+	c.DealIDs = slices.Clone(m.DealIDs)
+	c.Labels = maps.Clone(m.Labels)
+	if m.Expiration != nil {
+		t := *m.Expiration
+		c.Expiration = &t
+	}
+	return c
+}
@@ -0,0 +1,372 @@
+// Package retention expires pieces once their metadata says they are no
+// longer needed, deleting them or moving them to a cold storage. Sweeps run
+// as jobs and every action is recorded in the audit log.
+package retention
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/web3tea/piecehub/config"
+	"github.com/web3tea/piecehub/internal/audit"
+	"github.com/web3tea/piecehub/internal/jobs"
+	"github.com/web3tea/piecehub/internal/meta"
+	"github.com/web3tea/piecehub/storage"
+)
+
+// JobType is the job type of sweeps.
+const JobType = "retention"
+
+const (
+	ActionDelete = "delete"
+	ActionMove   = "move"
+)
+
+// Filecoin mainnet genesis and block time, to convert chain epochs.
+const (
+	genesisUnix   = 1598306400
+	epochDuration = 30 * time.Second
+)
+
+// EpochTime returns the time of a mainnet chain epoch.
+func EpochTime(epoch int64) time.Time {
+	return time.Unix(genesisUnix, 0).UTC().Add(time.Duration(epoch) * epochDuration)
+}
+
+// Expiry returns when a piece with metadata m expires and why: the
+// earliest of its expiration, its expiration epoch and the max age of the
+// first rule matching its labels. ok is false if it never expires.
+func Expiry(m *meta.Meta, rules []config.RetentionRule) (at time.Time, reason string, ok bool) {
+	consider := func(t time.Time, why string) {
+		if !ok || t.Before(at) {
+			at, reason, ok = t, why, true
+		}
+	}
+
+	for _, rule := range rules {
+		f := meta.Filter{Labels: rule.Labels}
+		if !f.Match(m) {
+			continue
+		}
+		if rule.Keep {
+			return time.Time{}, "", false
+		}
+		if rule.MaxAge > 0 {
+			consider(m.Created.Add(time.Duration(rule.MaxAge)*time.Second), "maxAge")
+		}
+		break
+	}
+	if m.Expiration != nil {
+		consider(*m.Expiration, "expiration")
+	}
+	if m.ExpirationEpoch > 0 {
+		consider(EpochTime(m.ExpirationEpoch), "expirationEpoch")
+	}
+	return at, reason, ok
+}
+
+// Record is one action on one copy of a piece.
+type Record struct {
+	Time    time.Time `json:"time"`
+	Piece   string    `json:"piece"`
+	Storage string    `json:"storage"`
+	Size    int64     `json:"size"`
+	Action  string    `json:"action"`
+	Target  string    `json:"target,omitempty"`
+	Expiry  time.Time `json:"expiry"`
+	Reason  string    `json:"reason"`
+	DryRun  bool      `json:"dryRun,omitempty"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// Report summarizes a sweep.
+type Report struct {
+	DryRun bool `json:"dryRun"`
+	// Checked pieces have metadata. Of these, InGrace have expired but are
+	// within the grace period and Expired are past it.
+	Checked int `json:"checked"`
+	InGrace int `json:"inGrace"`
+	Expired int `json:"expired"`
+	Failed  int `json:"failed"`
+	// Bytes deleted or moved, or that would be in a dry run.
+	Bytes   int64    `json:"bytes"`
+	Actions []Record `json:"actions"`
+}
+
+// Reaper sweeps expired pieces.
+type Reaper struct {
+	store storage.Manager
+	meta  *meta.Store
+	jobs  *jobs.Manager
+	audit *audit.Logger
+
+	mu      sync.Mutex
+	cfg     config.RetentionConfig
+	updated chan struct{}
+}
+
+func New(cfg *config.RetentionConfig, store storage.Manager, metaStore *meta.Store, jobMgr *jobs.Manager, auditLog *audit.Logger) *Reaper {
+	return &Reaper{
+		store:   store,
+		meta:    metaStore,
+		jobs:    jobMgr,
+		audit:   auditLog,
+		cfg:     *cfg,
+		updated: make(chan struct{}, 1),
+	}
+}
+
+// Update applies a new configuration to the schedule and later sweeps.
+func (r *Reaper) Update(cfg *config.RetentionConfig) {
+	r.mu.Lock()
+	r.cfg = *cfg
+	r.mu.Unlock()
+	select {
+	case r.updated <- struct{}{}:
+	default:
+	}
+}
+
+func (r *Reaper) config() config.RetentionConfig {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.cfg
+}
+
+// Run starts a sweep every interval while enabled, until ctx is done. A
+// sweep is skipped while the previous one is still queued or running.
+func (r *Reaper) Run(ctx context.Context) {
+	last := time.Now()
+	for {
+		cfg := r.config()
+		timer := time.NewTimer(time.Until(last.Add(time.Duration(cfg.Interval) * time.Second)))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-r.updated:
+			timer.Stop()
+			continue
+		case <-timer.C:
+		}
+		last = time.Now()
+
+		if !cfg.Enabled || r.busy() {
+			continue
+		}
+		if _, err := r.Start(ctx, false); err != nil {
+			slog.Error("start retention sweep", "err", err)
+		}
+	}
+}
+
+func (r *Reaper) busy() bool {
+	for _, j := range r.jobs.List(JobType) {
+		if !j.State.Finished() {
+			return true
+		}
+	}
+	return false
+}
+
+// Start submits a sweep job. The job is cancelled when ctx is done.
+// Sweeps only record what they would do when dryRun or the configuration
+// says so.
+func (r *Reaper) Start(ctx context.Context, dryRun bool) (jobs.Job, error) {
+	params := struct {
+		DryRun bool `json:"dryRun"`
+	}{dryRun}
+	return r.jobs.Submit(ctx, JobType, &params, func(ctx context.Context, p *jobs.Progress) (any, error) {
+		return r.Sweep(ctx, dryRun, p)
+	})
+}
+
+// Sweep deletes or moves every copy of the pieces past expiry and grace
+// period. With the move action copies already on the cold storage are
+// kept. Metadata of deleted pieces is removed once no copy is left.
+func (r *Reaper) Sweep(ctx context.Context, dryRun bool, p *jobs.Progress) (*Report, error) {
+	cfg := r.config()
+	dryRun = dryRun || cfg.DryRun
+	now := time.Now()
+	grace := time.Duration(cfg.GracePeriod) * time.Second
+
+	names := r.store.ListStorages()
+	sort.Strings(names)
+
+	report := &Report{DryRun: dryRun, Actions: []Record{}}
+	var due []Record
+	var expired []string
+	var total int64
+	for piece, m := range r.meta.Find(&meta.Filter{}) {
+		report.Checked++
+		at, reason, ok := Expiry(&m, cfg.Rules)
+		if !ok || now.Before(at) {
+			continue
+		}
+		if now.Before(at.Add(grace)) {
+			report.InGrace++
+			continue
+		}
+		report.Expired++
+		expired = append(expired, piece)
+
+		for _, name := range names {
+			if cfg.Action == ActionMove && name == cfg.ColdStorage {
+				continue
+			}
+			st, err := r.store.GetStorage(name)
+			if err != nil {
+				continue
+			}
+			size, err := st.Stats(ctx, piece)
+			if err != nil {
+				continue
+			}
+			rec := Record{
+				Piece:   piece,
+				Storage: name,
+				Size:    size,
+				Action:  cfg.Action,
+				Expiry:  at,
+				Reason:  reason,
+				DryRun:  dryRun,
+			}
+			if cfg.Action == ActionMove {
+				rec.Target = cfg.ColdStorage
+			}
+			due = append(due, rec)
+			total += size
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].Piece < due[j].Piece || due[i].Piece == due[j].Piece && due[i].Storage < due[j].Storage
+	})
+	p.SetTotal(total)
+
+	failed := make(map[string]bool)
+	for _, rec := range due {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if dryRun {
+			p.Add(rec.Size)
+		} else if err := r.apply(ctx, &rec, p); err != nil {
+			rec.Error = err.Error()
+			failed[rec.Piece] = true
+			report.Failed++
+		}
+		if rec.Error == "" {
+			report.Bytes += rec.Size
+		}
+		rec.Time = time.Now().UTC()
+		r.record(ctx, &rec)
+		report.Actions = append(report.Actions, rec)
+	}
+
+	if !dryRun && cfg.Action == ActionDelete {
+		for _, piece := range expired {
+			if failed[piece] {
+				continue
+			}
+			if err := r.meta.Delete(piece); err != nil {
+				slog.Error("delete metadata of expired piece", "piece", piece, "err", err)
+			}
+		}
+	}
+	return report, nil
+}
+
+// apply deletes or moves one copy of a piece.
+func (r *Reaper) apply(ctx context.Context, rec *Record, p *jobs.Progress) error {
+	src, err := r.store.GetStorage(rec.Storage)
+	if err != nil {
+		return err
+	}
+	if rec.Action == ActionMove {
+		if err := r.copy(ctx, src, rec, p); err != nil {
+			return err
+		}
+	}
+
+	defer r.store.Invalidate(rec.Piece)
+	if err := src.Delete(ctx, rec.Piece); err != nil {
+		return err
+	}
+	if rec.Action == ActionDelete {
+		p.Add(rec.Size)
+	}
+	return nil
+}
+
+// copy writes the piece to the target storage and checks its size there.
+func (r *Reaper) copy(ctx context.Context, src storage.Storage, rec *Record, p *jobs.Progress) error {
+	dst, err := r.store.GetStorage(rec.Target)
+	if err != nil {
+		return err
+	}
+	if size, err := dst.Stats(ctx, rec.Piece); err == nil && size == rec.Size {
+		// left by an interrupted sweep
+		p.Add(rec.Size)
+		return nil
+	}
+
+	rd, err := src.Read(ctx, rec.Piece)
+	if err != nil {
+		return err
+	}
+	defer rd.Close()
+	if err := dst.Write(ctx, rec.Piece, io.TeeReader(rd, p), rec.Size); err != nil {
+		return fmt.Errorf("copy to %s: %w", rec.Target, err)
+	}
+	size, err := dst.Stats(ctx, rec.Piece)
+	if err != nil {
+		return fmt.Errorf("verify copy on %s: %w", rec.Target, err)
+	}
+	if size != rec.Size {
+		return fmt.Errorf("verify copy on %s: size %d, expected %d", rec.Target, size, rec.Size)
+	}
+	return nil
+}
+
+// record logs rec and appends it to the audit log.
+func (r *Reaper) record(ctx context.Context, rec *Record) {
+	attrs := []any{
+		"piece", rec.Piece,
+		"storage", rec.Storage,
+		"action", rec.Action,
+		"size", rec.Size,
+		"expiry", rec.Expiry,
+		"reason", rec.Reason,
+		"dry_run", rec.DryRun,
+	}
+	if rec.Target != "" {
+		attrs = append(attrs, "target", rec.Target)
+	}
+	if rec.Error != "" {
+		slog.Error("retention action failed", append(attrs, "err", rec.Error)...)
+	} else {
+		slog.Info("retention action", attrs...)
+	}
+
+	action := "piece.expire"
+	if rec.Action == ActionMove {
+		action = "piece.migrate"
+	}
+	e := audit.NewEvent(ctx, action)
+	e.Time = rec.Time
+	e.Piece, e.Storage, e.Target, e.Size = rec.Piece, rec.Storage, rec.Target, rec.Size
+	e.Reason, e.DryRun = rec.Reason, rec.DryRun
+	e.Outcome, e.Error = audit.Success, rec.Error
+	if rec.Error != "" {
+		e.Outcome = audit.Failure
+	}
+	r.audit.Log(e)
+}
@@ -0,0 +1,152 @@
+// Package tokenhash hashes and verifies API tokens so configs can store
+// digests instead of the tokens themselves.
+//
+// Supported forms are "sha256:<hex digest>" and argon2id PHC strings
+// ("$argon2id$v=19$m=65536,t=3,p=4$<salt>$<hash>"). Anything else is a
+// plaintext token.
+package tokenhash
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	sha256Prefix   = "sha256:"
+	argon2idPrefix = "$argon2id$"
+
+	argonTime    = 3
+	argonMemory  = 64 * 1024
+	argonThreads = 4
+	argonKeyLen  = 32
+	argonSaltLen = 16
+)
+
+type Kind int
+
+const (
+	Plain Kind = iota
+	SHA256
+	Argon2id
+)
+
+// KindOf reports how a configured token entry is stored.
+func KindOf(entry string) Kind {
+	switch {
+	case strings.HasPrefix(entry, sha256Prefix):
+		return SHA256
+	case strings.HasPrefix(entry, argon2idPrefix):
+		return Argon2id
+	default:
+		return Plain
+	}
+}
+
+// Validate checks that a hashed entry is well formed.
+func Validate(entry string) error {
+	switch KindOf(entry) {
+	case SHA256:
+		_, err := SHA256Digest(entry)
+		return err
+	case Argon2id:
+		_, err := parseArgon2id(entry)
+		return err
+	default:
+		return nil
+	}
+}
+
+// Hash returns the entry to put in the config for token, using "sha256" or
+// "argon2id".
+func Hash(token, algo string) (string, error) {
+	switch algo {
+	case "sha256":
+		sum := sha256.Sum256([]byte(token))
+		return sha256Prefix + hex.EncodeToString(sum[:]), nil
+	case "argon2id":
+		salt := make([]byte, argonSaltLen)
+		if _, err := rand.Read(salt); err != nil {
+			return "", err
+		}
+		key := argon2.IDKey([]byte(token), salt, argonTime, argonMemory, argonThreads, argonKeyLen)
+		return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+			argon2.Version, argonMemory, argonTime, argonThreads,
+			base64.RawStdEncoding.EncodeToString(salt),
+			base64.RawStdEncoding.EncodeToString(key)), nil
+	default:
+		return "", fmt.Errorf("unknown hash algorithm %q", algo)
+	}
+}
+
+// SHA256Digest returns the lowercase hex digest of a "sha256:" entry.
+func SHA256Digest(entry string) (string, error) {
+	digest := strings.ToLower(strings.TrimPrefix(entry, sha256Prefix))
+	if b, err := hex.DecodeString(digest); err != nil || len(b) != sha256.Size {
+		return "", fmt.Errorf("invalid sha256 token hash")
+	}
+	return digest, nil
+}
+
+// SumSHA256 returns the hex digest of token, comparable to SHA256Digest.
+func SumSHA256(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+type argon2idHash struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+	salt    []byte
+	key     []byte
+}
+
+func parseArgon2id(entry string) (*argon2idHash, error) {
+	parts := strings.Split(entry, "$")
+	if len(parts) != 6 {
+		return nil, fmt.Errorf("invalid argon2id token hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, fmt.Errorf("unsupported argon2id version")
+	}
+
+	h := &argon2idHash{}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &h.memory, &h.time, &h.threads); err != nil {
+		return nil, fmt.Errorf("invalid argon2id parameters: %w", err)
+	}
+
+	var err error
+	if h.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, fmt.Errorf("invalid argon2id salt: %w", err)
+	}
+	if h.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return nil, fmt.Errorf("invalid argon2id hash: %w", err)
+	}
+	// argon2.IDKey panics on zero time or threads
+	if h.memory == 0 || h.time == 0 || h.threads == 0 {
+		return nil, fmt.Errorf("invalid argon2id parameters: m, t and p must be positive")
+	}
+	if len(h.salt) == 0 || len(h.key) == 0 {
+		return nil, fmt.Errorf("invalid argon2id token hash: empty salt or hash")
+	}
+	return h, nil
+}
+
+// VerifyArgon2id reports whether token matches an argon2id entry.
+func VerifyArgon2id(entry, token string) bool {
+	h, err := parseArgon2id(entry)
+	if err != nil {
+		return false
+	}
+	key := argon2.IDKey([]byte(token), h.salt, h.time, h.memory, h.threads, uint32(len(h.key)))
+	return subtle.ConstantTimeCompare(key, h.key) == 1
+}
@@ -0,0 +1,39 @@
+package tokenhash
+
+import "testing"
+
+func TestArgon2idRoundTrip(t *testing.T) {
+	entry, err := Hash("secret", "argon2id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Validate(entry); err != nil {
+		t.Fatalf("validate %s: %v", entry, err)
+	}
+	if !VerifyArgon2id(entry, "secret") {
+		t.Error("token does not match its own hash")
+	}
+	if VerifyArgon2id(entry, "other") {
+		t.Error("other token matches")
+	}
+}
+
+func TestValidateRejectsDegenerateArgon2id(t *testing.T) {
+	const salt, key = "c2FsdHNhbHRzYWx0c2FsdA", "a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2U"
+	entries := map[string]string{
+		"zero time":    "$argon2id$v=19$m=65536,t=0,p=4$" + salt + "$" + key,
+		"zero threads": "$argon2id$v=19$m=65536,t=3,p=0$" + salt + "$" + key,
+		"zero memory":  "$argon2id$v=19$m=0,t=3,p=4$" + salt + "$" + key,
+		"empty salt":   "$argon2id$v=19$m=65536,t=3,p=4$$" + key,
+		"empty hash":   "$argon2id$v=19$m=65536,t=3,p=4$" + salt + "$",
+	}
+	for name, entry := range entries {
+		if err := Validate(entry); err == nil {
+			t.Errorf("%s: %s validated", name, entry)
+		}
+		// must not reach argon2.IDKey, which panics on these
+		if VerifyArgon2id(entry, "secret") {
+			t.Errorf("%s: %s verified", name, entry)
+		}
+	}
+}
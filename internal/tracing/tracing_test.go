@@ -0,0 +1,55 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/web3tea/piecehub/config"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+func TestSetupExportsSampledSpans(t *testing.T) {
+	ctx := context.Background()
+	exporter := tracetest.NewInMemoryExporter()
+	// with a ratio of 0 only spans of sampled remote parents are recorded
+	shutdown, err := Setup(ctx, &config.TracingConfig{ServiceName: "test", SampleRatio: 0}, exporter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shutdown(ctx)
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	header := http.Header{"Traceparent": {"00-" + traceID + "-00f067aa0ba902b7-01"}}
+	remote := otel.GetTextMapPropagator().Extract(ctx, propagation.HeaderCarrier(header))
+	_, child := Tracer().Start(remote, "child")
+	child.End()
+	_, root := Tracer().Start(ctx, "root")
+	root.End()
+
+	// the in-memory exporter is reset on shutdown, so flush instead
+	if err := otel.GetTracerProvider().(*sdktrace.TracerProvider).ForceFlush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	spans := exporter.GetSpans().Snapshots()
+	if len(spans) != 1 || spans[0].Name() != "child" {
+		t.Fatalf("got %d spans, want only the child of the sampled parent", len(spans))
+	}
+	s := spans[0]
+	if got := s.SpanContext().TraceID().String(); got != traceID {
+		t.Errorf("trace id %s, want %s from traceparent", got, traceID)
+	}
+	var service string
+	for _, kv := range s.Resource().Attributes() {
+		if kv.Key == semconv.ServiceNameKey {
+			service = kv.Value.Emit()
+		}
+	}
+	if service != "test" {
+		t.Errorf("service name %q, want %q", service, "test")
+	}
+}
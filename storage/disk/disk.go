@@ -2,16 +2,29 @@ package disk
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 
 	"github.com/web3tea/piecehub/config"
 )
 
 type DiskStorage struct {
 	cfg *config.DiskConfig
+
+	mu sync.Mutex
+	// checking is the check in progress, shared by callers so that checks
+	// of a hung mount do not pile up
+	checking *diskCheck
+}
+
+type diskCheck struct {
+	done chan struct{}
+	err  error
 }
 
 func New(cfg *config.DiskConfig) (*DiskStorage, error) {
@@ -19,7 +32,16 @@ func New(cfg *config.DiskConfig) (*DiskStorage, error) {
 		cfg: cfg,
 	}
 
-	if err := os.MkdirAll(ds.cfg.RootDir, 0755); err != nil {
+	if _, err := os.Stat(ds.cfg.RootDir); os.IsNotExist(err) {
+		if !ds.cfg.Create {
+			return nil, fmt.Errorf("root dir %s does not exist (set create = true to create it)", ds.cfg.RootDir)
+		}
+		if err := os.MkdirAll(ds.cfg.RootDir, 0755); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := ds.Check(context.Background()); err != nil {
 		return nil, err
 	}
 
@@ -31,9 +53,67 @@ func (ds *DiskStorage) Name() string {
 	return ds.cfg.Name
 }
 
+// Check implements storage.Storage. The root must be a writable directory
+// and, with require_mount, a mountpoint, so that an unmounted volume is
+// not silently replaced by the directory below it. The file system calls
+// run in the background, so a hung mount fails the check once ctx ends.
+func (ds *DiskStorage) Check(ctx context.Context) error {
+	ds.mu.Lock()
+	c := ds.checking
+	if c == nil {
+		c = &diskCheck{done: make(chan struct{})}
+		ds.checking = c
+		go func() {
+			c.err = ds.check()
+			ds.mu.Lock()
+			ds.checking = nil
+			ds.mu.Unlock()
+			close(c.done)
+		}()
+	}
+	ds.mu.Unlock()
+
+	select {
+	case <-c.done:
+		return c.err
+	case <-ctx.Done():
+		return fmt.Errorf("check %s: %w", ds.cfg.RootDir, ctx.Err())
+	}
+}
+
+func (ds *DiskStorage) check() error {
+	fi, err := os.Stat(ds.cfg.RootDir)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("%s is not a directory", ds.cfg.RootDir)
+	}
+
+	if ds.cfg.RequireMount {
+		mounted, err := isMountpoint(ds.cfg.RootDir)
+		if err != nil {
+			return fmt.Errorf("check mountpoint: %w", err)
+		}
+		if !mounted {
+			return fmt.Errorf("root dir %s is not a mountpoint", ds.cfg.RootDir)
+		}
+	}
+
+	f, err := os.CreateTemp(ds.cfg.RootDir, ".piecehub-check-*")
+	if err != nil {
+		return fmt.Errorf("root not writable: %w", err)
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
 // Stats implements storage.Storage.
 func (ds *DiskStorage) Stats(ctx context.Context, name string) (int64, error) {
-	path := ds.getPiecePath(name)
+	path, err := ds.getPiecePath(name)
+	if err != nil {
+		return 0, err
+	}
 
 	fileInfo, err := os.Stat(path)
 	if err != nil {
@@ -42,41 +122,124 @@ func (ds *DiskStorage) Stats(ctx context.Context, name string) (int64, error) {
 	return fileInfo.Size(), nil
 }
 
+// List implements storage.Storage. Hidden files and directories are
+// skipped.
+func (ds *DiskStorage) List(ctx context.Context, fn func(name string, size int64) error) error {
+	entries, err := os.ReadDir(ds.cfg.RootDir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		info, err := entry.Info()
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(entry.Name(), info.Size()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Delete implements storage.Storage.
 func (ds *DiskStorage) Delete(ctx context.Context, name string) error {
-	path := ds.getPiecePath(name)
+	path, err := ds.getPiecePath(name)
+	if err != nil {
+		return err
+	}
 	return os.Remove(path)
 }
 
 // Read implements storage.Storage.
 func (ds *DiskStorage) Read(ctx context.Context, name string) (io.ReadSeekCloser, error) {
-	path := ds.getPiecePath(name)
+	path, err := ds.getPiecePath(name)
+	if err != nil {
+		return nil, err
+	}
 	return os.OpenFile(path, os.O_RDONLY, 0644)
 }
 
 // CopyToHTTP implements storage.Storage.
 func (ds *DiskStorage) CopyToHTTP(ctx context.Context, name string, w http.ResponseWriter, req *http.Request) error {
-	path := ds.getPiecePath(name)
+	path, err := ds.getPiecePath(name)
+	if err != nil {
+		return err
+	}
 	http.ServeFile(w, req, path)
 	return nil
 }
 
-// Write implements storage.Storage.
-func (ds *DiskStorage) Write(ctx context.Context, name string, reader io.Reader) error {
-	fp := ds.getPiecePath(name)
+// Write implements storage.Storage. Data goes to a hidden temp file that
+// is renamed into place once complete, so partial pieces are never served.
+func (ds *DiskStorage) Write(ctx context.Context, name string, reader io.Reader, size int64) error {
+	fp, err := ds.getPiecePath(name)
+	if err != nil {
+		return err
+	}
 
-	writer, err := os.Create(fp)
+	writer, err := os.CreateTemp(ds.cfg.RootDir, ".piecehub-write-*")
 	if err != nil {
 		return err
 	}
-	defer writer.Close()
+	defer os.Remove(writer.Name())
 
-	if _, err := io.Copy(writer, reader); err != nil {
+	n, err := io.Copy(writer, reader)
+	if err == nil && size >= 0 && n != size {
+		err = fmt.Errorf("short write: wrote %d of %d bytes", n, size)
+	}
+	if cerr := writer.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
 		return err
 	}
-	return nil
+	if err := os.Chmod(writer.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(writer.Name(), fp)
+}
+
+// Rename implements storage.Storage.
+func (ds *DiskStorage) Rename(ctx context.Context, from, to string) error {
+	src, err := ds.getPiecePath(from)
+	if err != nil {
+		return err
+	}
+	dst, err := ds.getPiecePath(to)
+	if err != nil {
+		return err
+	}
+	return os.Rename(src, dst)
+}
+
+// Link adds an existing file as a piece without copying it. With move the
+// file is renamed into place, otherwise it is hard linked. Both fail when
+// path is on another filesystem than the root.
+func (ds *DiskStorage) Link(name, path string, move bool) error {
+	dst, err := ds.getPiecePath(name)
+	if err != nil {
+		return err
+	}
+	if move {
+		return os.Rename(path, dst)
+	}
+	return os.Link(path, dst)
 }
 
-func (ds *DiskStorage) getPiecePath(name string) string {
-	return filepath.Join(ds.cfg.RootDir, name)
+// getPiecePath returns the file of a piece, rejecting names that would
+// resolve outside the root.
+func (ds *DiskStorage) getPiecePath(name string) (string, error) {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return "", fmt.Errorf("invalid piece name %q", name)
+	}
+	return filepath.Join(ds.cfg.RootDir, name), nil
 }
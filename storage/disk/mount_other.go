@@ -0,0 +1,9 @@
+//go:build !unix
+
+package disk
+
+import "errors"
+
+func isMountpoint(path string) (bool, error) {
+	return false, errors.New("require_mount is not supported on this platform")
+}
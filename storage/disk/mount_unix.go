@@ -0,0 +1,45 @@
+//go:build unix
+
+package disk
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// isMountpoint reports whether path is on a different device than its
+// parent, or is the filesystem root.
+func isMountpoint(path string) (bool, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return false, err
+	}
+	parent := filepath.Dir(path)
+	if parent == path {
+		return true, nil
+	}
+
+	dev, err := deviceID(path)
+	if err != nil {
+		return false, err
+	}
+	parentDev, err := deviceID(parent)
+	if err != nil {
+		return false, err
+	}
+	return dev != parentDev, nil
+}
+
+func deviceID(path string) (uint64, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, fmt.Errorf("unsupported stat type for %s", path)
+	}
+	return uint64(st.Dev), nil
+}
@@ -2,20 +2,27 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"reflect"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/hashicorp/golang-lru/v2/expirable"
 	"github.com/web3tea/piecehub/config"
+	"github.com/web3tea/piecehub/internal/logging"
+	"github.com/web3tea/piecehub/internal/tracing"
 	"github.com/web3tea/piecehub/storage/disk"
 	"github.com/web3tea/piecehub/storage/s3"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type StorageManager struct {
 	storages map[string]Storage
+	configs  map[string]any
 	cache    *expirable.LRU[string, *pieceCache]
 	mu       sync.RWMutex
 }
@@ -23,23 +30,12 @@ type StorageManager struct {
 func NewManager(cfg *config.Config) (Manager, error) {
 	m := &StorageManager{
 		storages: make(map[string]Storage),
+		configs:  make(map[string]any),
 		cache:    expirable.NewLRU[string, *pieceCache](1024*1024, nil, time.Minute),
 	}
 
-	for _, diskCfg := range cfg.Disks {
-		store, err := disk.New(&diskCfg)
-		if err != nil {
-			return nil, fmt.Errorf("failed to create disk storage %s: %v", diskCfg.Name, err)
-		}
-		m.storages[diskCfg.Name] = store
-	}
-
-	for _, s3Cfg := range cfg.S3s {
-		store, err := s3.New(&s3Cfg)
-		if err != nil {
-			return nil, fmt.Errorf("failed to create s3 storage %s: %v", s3Cfg.Name, err)
-		}
-		m.storages[s3Cfg.Name] = store
+	if _, err := m.Update(cfg); err != nil {
+		return nil, err
 	}
 
 	return m, nil
@@ -50,56 +46,178 @@ type pieceCache struct {
 	Size    int64
 }
 
-// Delete implements Storage.
-func (m *StorageManager) Delete(ctx context.Context, name string) error {
-	panic("unimplemented")
+// UpdateResult describes the storages changed by Update.
+type UpdateResult struct {
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+	Updated []string `json:"updated"`
 }
 
-// Read implements Storage.
-func (m *StorageManager) Read(ctx context.Context, name string) (io.ReadSeekCloser, error) {
-	if pc, ok := m.cache.Get(name); ok {
-		store, err := m.GetStorage(pc.Storage)
+// Update reconciles the running storages with cfg. Storages whose
+// configuration is unchanged are kept as is, so in-flight transfers are not
+// interrupted; new and changed storages are created before anything is
+// swapped, and if any of them fails the running set is left untouched.
+func (m *StorageManager) Update(cfg *config.Config) (*UpdateResult, error) {
+	// keep config order so errors are reported deterministically
+	var names []string
+	wanted := make(map[string]any)
+	for _, diskCfg := range cfg.Disks {
+		names = append(names, diskCfg.Name)
+		wanted[diskCfg.Name] = diskCfg
+	}
+	for _, s3Cfg := range cfg.S3s {
+		names = append(names, s3Cfg.Name)
+		wanted[s3Cfg.Name] = s3Cfg
+	}
+
+	m.mu.RLock()
+	current := make(map[string]any, len(m.configs))
+	for name, c := range m.configs {
+		current[name] = c
+	}
+	m.mu.RUnlock()
+
+	res := &UpdateResult{}
+	created := make(map[string]Storage)
+	var errs []error
+	for _, name := range names {
+		c := wanted[name]
+		old, ok := current[name]
+		if ok && reflect.DeepEqual(old, c) {
+			continue
+		}
+		store, err := newStorage(c)
 		if err != nil {
-			return nil, err
+			errs = append(errs, err)
+			continue
+		}
+		created[name] = store
+		if ok {
+			res.Updated = append(res.Updated, name)
+		} else {
+			res.Added = append(res.Added, name)
 		}
-		return store.Read(ctx, name)
 	}
-	for _, store := range m.storages {
-		if size, err := store.Stats(ctx, name); err == nil {
-			m.cache.Add(name, &pieceCache{Storage: store.Name(), Size: size})
-			return store.Read(ctx, name)
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	for name := range current {
+		if _, ok := wanted[name]; !ok {
+			res.Removed = append(res.Removed, name)
 		}
 	}
-	return nil, fmt.Errorf("piece not found: %s", name)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for name, store := range created {
+		m.storages[name] = store
+		m.configs[name] = wanted[name]
+	}
+	for _, name := range res.Removed {
+		delete(m.storages, name)
+		delete(m.configs, name)
+	}
+	if len(res.Updated) > 0 || len(res.Removed) > 0 {
+		m.cache.Purge()
+	}
+
+	return res, nil
+}
+
+func newStorage(c any) (Storage, error) {
+	switch c := c.(type) {
+	case config.DiskConfig:
+		store, err := disk.New(&c)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create disk storage %s: %v", c.Name, err)
+		}
+		return traced(store), nil
+	case config.S3Config:
+		store, err := s3.New(&c)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create s3 storage %s: %v", c.Name, err)
+		}
+		return traced(store), nil
+	default:
+		return nil, fmt.Errorf("unknown storage config type %T", c)
+	}
+}
+
+// Delete implements Storage. The piece is removed from the storage
+// holding it.
+func (m *StorageManager) Delete(ctx context.Context, name string) error {
+	store, _, err := m.Locate(ctx, name)
+	if err != nil {
+		return err
+	}
+	m.cache.Remove(name)
+	return store.Delete(ctx, name)
+}
+
+// Read implements Storage.
+func (m *StorageManager) Read(ctx context.Context, name string) (io.ReadSeekCloser, error) {
+	store, _, err := m.Locate(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return store.Read(ctx, name)
 }
 
 // Stats implements Storage.
 func (m *StorageManager) Stats(ctx context.Context, name string) (int64, error) {
-	if pc, ok := m.cache.Get(name); ok {
-		return pc.Size, nil
+	_, size, err := m.Locate(ctx, name)
+	return size, err
+}
+
+// ValidName reports whether name can be a piece. Names with path
+// separators are rejected, as are hidden names, which backends use for
+// temporary data.
+func ValidName(name string) bool {
+	return name != "" && !strings.HasPrefix(name, ".") && !strings.ContainsAny(name, `/\`)
+}
+
+// Locate finds the storage holding a piece and its size.
+func (m *StorageManager) Locate(ctx context.Context, name string) (Storage, int64, error) {
+	if !ValidName(name) {
+		return nil, 0, fmt.Errorf("invalid piece name %q", name)
 	}
-	for _, store := range m.storages {
+	ctx, span := m.startLookup(ctx, "Locate", name)
+	defer span.End()
+
+	if pc, ok := m.cacheGet(span, name); ok {
+		store, err := m.GetStorage(pc.Storage)
+		if err == nil {
+			logging.AddFields(ctx, "storage", pc.Storage)
+			return store, pc.Size, nil
+		}
+		m.cache.Remove(name)
+	}
+	for _, store := range m.snapshot() {
 		if size, err := store.Stats(ctx, name); err == nil {
 			m.cache.Add(name, &pieceCache{Storage: store.Name(), Size: size})
-			return size, nil
+			logging.AddFields(ctx, "storage", store.Name())
+			return store, size, nil
 		}
 	}
-	return 0, fmt.Errorf("piece not found: %s", name)
+	return nil, 0, fmt.Errorf("piece not found: %s", name)
+}
+
+// Invalidate implements Manager.
+func (m *StorageManager) Invalidate(name string) {
+	m.cache.Remove(name)
 }
 
 func (m *StorageManager) CopyToHTTP(ctx context.Context, name string, w http.ResponseWriter, req *http.Request) error {
-	if pc, ok := m.cache.Get(name); ok {
-		store, err := m.GetStorage(pc.Storage)
-		if err != nil {
-			return err
-		}
-		return store.CopyToHTTP(ctx, name, w, req)
+	store, _, err := m.Locate(ctx, name)
+	if err != nil {
+		return err
 	}
-	return fmt.Errorf("piece not found: %s", name)
+	return store.CopyToHTTP(ctx, name, w, req)
 }
 
 // Write implements Storage.
-func (m *StorageManager) Write(ctx context.Context, name string, reader io.Reader) error {
+func (m *StorageManager) Write(ctx context.Context, name string, reader io.Reader, size int64) error {
 	panic("unimplemented")
 }
 
@@ -123,3 +241,67 @@ func (m *StorageManager) ListStorages() []string {
 	}
 	return names
 }
+
+func (m *StorageManager) startLookup(ctx context.Context, op, name string) (context.Context, trace.Span) {
+	return tracing.Tracer().Start(ctx, "StorageManager."+op, trace.WithAttributes(
+		tracing.AttrPieceCID.String(name),
+	))
+}
+
+// cacheGet looks up the piece location cache and records the outcome on span.
+func (m *StorageManager) cacheGet(span trace.Span, name string) (*pieceCache, bool) {
+	pc, ok := m.cache.Get(name)
+	span.SetAttributes(tracing.AttrCacheHit.Bool(ok))
+	if ok {
+		span.SetAttributes(tracing.AttrStorage.String(pc.Storage))
+	}
+	return pc, ok
+}
+
+// Check runs the checks of all storages concurrently, keyed by storage name.
+// Storages that have not answered when ctx ends are reported as timed out,
+// so a hung backend cannot block the caller.
+func (m *StorageManager) Check(ctx context.Context) map[string]error {
+	stores := m.snapshot()
+
+	type result struct {
+		name string
+		err  error
+	}
+	// buffered, so that late checks do not block after Check returned
+	done := make(chan result, len(stores))
+	for _, store := range stores {
+		go func() {
+			done <- result{store.Name(), store.Check(ctx)}
+		}()
+	}
+
+	results := make(map[string]error, len(stores))
+	for range stores {
+		select {
+		case r := <-done:
+			results[r.name] = r.err
+		case <-ctx.Done():
+			for _, store := range stores {
+				if _, ok := results[store.Name()]; !ok {
+					results[store.Name()] = fmt.Errorf("check timed out: %w", ctx.Err())
+				}
+			}
+			return results
+		}
+	}
+	return results
+}
+
+// snapshot returns the current storages so callers can iterate them without
+// holding the lock across backend calls.
+func (m *StorageManager) snapshot() []Storage {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	stores := make([]Storage, 0, len(m.storages))
+	for _, store := range m.storages {
+		stores = append(stores, store)
+	}
+	return stores
+}
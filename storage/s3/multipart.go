@@ -0,0 +1,167 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/web3tea/piecehub/internal/logging"
+)
+
+// writeMultipart uploads size bytes from reader to key in parts, continuing
+// an incomplete upload of key left by an earlier attempt. Parts of that
+// upload are still read from reader but only sent again when their MD5
+// differs from the stored ETag. A failed upload is left incomplete so that
+// a retry can resume it; stale ones are removed by AbortIncompleteUploads.
+func (s *S3Storage) writeMultipart(ctx context.Context, key string, reader io.Reader, size int64, partSize int64) error {
+	core := minio.Core{Client: s.client}
+	log := logging.FromContext(ctx)
+
+	uploadID, uploaded, err := s.incompleteUpload(ctx, key)
+	if err != nil {
+		return err
+	}
+	if uploadID == "" {
+		uploadID, err = core.NewMultipartUpload(ctx, s.cfg.Bucket, key, minio.PutObjectOptions{})
+		if err != nil {
+			return fmt.Errorf("start multipart upload: %w", err)
+		}
+	} else {
+		log.Info("resuming multipart upload", "storage", s.cfg.Name, "key", key, "upload", uploadID, "parts", len(uploaded))
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+	}
+
+	// each worker owns a buffer, so at most UploadConcurrency parts are
+	// held in memory
+	workers := max(int(s.cfg.UploadConcurrency), 1)
+	bufs := make(chan []byte, workers)
+	for range workers {
+		bufs <- nil
+	}
+
+	parts := make([]minio.CompletePart, (size+partSize-1)/partSize)
+	skipped := 0
+	for num, off := 1, int64(0); off < size; num, off = num+1, off+partSize {
+		n := min(partSize, size-off)
+		var buf []byte
+		select {
+		case buf = <-bufs:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		if int64(cap(buf)) < n {
+			buf = make([]byte, partSize)
+		}
+		buf = buf[:n]
+		if _, err := io.ReadFull(reader, buf); err != nil {
+			fail(fmt.Errorf("read part %d: %w", num, err))
+			break
+		}
+
+		sum := md5.Sum(buf)
+		parts[num-1].PartNumber = num
+		if p, ok := uploaded[num]; ok && p.Size == n && strings.Trim(p.ETag, `"`) == hex.EncodeToString(sum[:]) {
+			parts[num-1].ETag = p.ETag
+			skipped++
+			bufs <- buf
+			continue
+		}
+
+		wg.Add(1)
+		go func(num int, buf []byte) {
+			defer wg.Done()
+			defer func() { bufs <- buf }()
+			p, err := core.PutObjectPart(ctx, s.cfg.Bucket, key, uploadID, num, bytes.NewReader(buf), int64(len(buf)), minio.PutObjectPartOptions{})
+			if err != nil {
+				fail(fmt.Errorf("upload part %d: %w", num, err))
+				return
+			}
+			parts[num-1].ETag = p.ETag
+		}(num, buf)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if _, err := core.CompleteMultipartUpload(ctx, s.cfg.Bucket, key, uploadID, parts, minio.PutObjectOptions{}); err != nil {
+		return fmt.Errorf("complete multipart upload: %w", err)
+	}
+	if skipped > 0 {
+		log.Info("resumed multipart upload", "storage", s.cfg.Name, "key", key, "skipped_parts", skipped, "parts", len(parts))
+	}
+	return nil
+}
+
+// incompleteUpload returns the newest incomplete upload of key and its
+// parts by number, or an empty id if there is none.
+func (s *S3Storage) incompleteUpload(ctx context.Context, key string) (string, map[int]minio.ObjectPart, error) {
+	core := minio.Core{Client: s.client}
+	var latest minio.ObjectMultipartInfo
+	keyMarker, uploadIDMarker := "", ""
+	for {
+		res, err := core.ListMultipartUploads(ctx, s.cfg.Bucket, key, keyMarker, uploadIDMarker, "", 1000)
+		if minio.ToErrorResponse(err).Code == "NoSuchUpload" {
+			// some implementations report this for buckets without uploads
+			return "", nil, nil
+		}
+		if err != nil {
+			return "", nil, fmt.Errorf("list incomplete uploads: %w", err)
+		}
+		for _, info := range res.Uploads {
+			if info.Key == key && info.Initiated.After(latest.Initiated) {
+				latest = info
+			}
+		}
+		if !res.IsTruncated {
+			break
+		}
+		keyMarker, uploadIDMarker = res.NextKeyMarker, res.NextUploadIDMarker
+	}
+	if latest.UploadID == "" {
+		return "", nil, nil
+	}
+
+	parts := make(map[int]minio.ObjectPart)
+	marker := 0
+	for {
+		res, err := core.ListObjectParts(ctx, s.cfg.Bucket, key, latest.UploadID, marker, 1000)
+		if err != nil {
+			return "", nil, fmt.Errorf("list parts of upload %s: %w", latest.UploadID, err)
+		}
+		for _, p := range res.ObjectParts {
+			parts[p.PartNumber] = p
+		}
+		if !res.IsTruncated {
+			break
+		}
+		marker = res.NextPartNumberMarker
+	}
+	return latest.UploadID, parts, nil
+}
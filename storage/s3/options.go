@@ -0,0 +1,85 @@
+package s3
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/web3tea/piecehub/config"
+)
+
+func newTransport(cfg *config.S3Config) (*http.Transport, error) {
+	tr, err := minio.DefaultTransport(cfg.UseSSL)
+	if err != nil {
+		return nil, fmt.Errorf("create transport: %w", err)
+	}
+	if !cfg.UseSSL {
+		return tr, nil
+	}
+
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read ca file: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.CAFile)
+		}
+		tr.TLSClientConfig.RootCAs = pool
+	}
+	tr.TLSClientConfig.InsecureSkipVerify = cfg.InsecureSkipVerify
+
+	return tr, nil
+}
+
+func newCredentials(cfg *config.S3Config) *credentials.Credentials {
+	sources := cfg.Credentials
+	if len(sources) == 0 {
+		if cfg.AccessKey != "" {
+			sources = []string{"static"}
+		} else {
+			sources = []string{"env", "file", "iam"}
+		}
+	}
+
+	var providers []credentials.Provider
+	for _, src := range sources {
+		switch src {
+		case "static":
+			providers = append(providers, &credentials.Static{Value: credentials.Value{
+				AccessKeyID:     cfg.AccessKey,
+				SecretAccessKey: cfg.SecretKey,
+				SessionToken:    cfg.SessionToken,
+				SignerType:      credentials.SignatureV4,
+			}})
+		case "env":
+			providers = append(providers, &credentials.EnvAWS{}, &credentials.EnvMinio{})
+		case "file":
+			providers = append(providers, &credentials.FileAWSCredentials{
+				Filename: cfg.CredentialsFile,
+				Profile:  cfg.Profile,
+			})
+		case "iam":
+			providers = append(providers, &credentials.IAM{})
+		}
+	}
+	return credentials.NewChainCredentials(providers)
+}
+
+func bucketLookup(s string) minio.BucketLookupType {
+	switch s {
+	case "dns":
+		return minio.BucketLookupDNS
+	case "path":
+		return minio.BucketLookupPath
+	default:
+		return minio.BucketLookupAuto
+	}
+}
@@ -0,0 +1,218 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+)
+
+const (
+	defaultReadChunkSize = 16 << 20
+)
+
+type chunkResult struct {
+	data []byte
+	err  error
+}
+
+// rangedReader reads an object with up to concurrency ranged GETs in flight
+// and hands the chunks out in order. Fetching starts on the first Read after
+// a Seek, so seeking around, as http.ServeContent does to find the size,
+// costs no requests beyond the first chunk fetched when opening.
+type rangedReader struct {
+	ctx         context.Context
+	client      *minio.Client
+	bucket      string
+	key         string
+	etag        string
+	size        int64
+	chunkSize   int64
+	concurrency int
+
+	// first is the first chunk, kept from opening until it is read
+	first  []byte
+	offset int64
+	buf    []byte
+	queue  chan chan chunkResult
+	cancel context.CancelFunc
+}
+
+// openRangedReader fetches the first chunk of key. Its response carries the
+// size and ETag of the object, so no separate stat request is needed.
+func openRangedReader(ctx context.Context, client *minio.Client, bucket, key string, chunkSize int64, concurrency int) (*rangedReader, error) {
+	opts := minio.GetObjectOptions{}
+	if err := opts.SetRange(0, chunkSize-1); err != nil {
+		return nil, err
+	}
+	body, info, header, err := minio.Core{Client: client}.GetObject(ctx, bucket, key, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close() // nolint: errcheck
+
+	size, err := objectSize(header.Get("Content-Range"), info.Size)
+	if err != nil {
+		return nil, err
+	}
+	first := make([]byte, min(chunkSize, size))
+	if _, err := io.ReadFull(body, first); err != nil {
+		return nil, fmt.Errorf("read range 0-%d: %w", len(first)-1, err)
+	}
+
+	return &rangedReader{
+		ctx:         ctx,
+		client:      client,
+		bucket:      bucket,
+		key:         key,
+		etag:        info.ETag,
+		size:        size,
+		chunkSize:   chunkSize,
+		concurrency: concurrency,
+		first:       first,
+	}, nil
+}
+
+// objectSize returns the full object size from a Content-Range header such
+// as "bytes 0-99/1000", or length when the server sent the whole object.
+func objectSize(contentRange string, length int64) (int64, error) {
+	if contentRange == "" {
+		return length, nil
+	}
+	i := strings.LastIndexByte(contentRange, '/')
+	if i < 0 {
+		return 0, fmt.Errorf("invalid Content-Range %q", contentRange)
+	}
+	size, err := strconv.ParseInt(contentRange[i+1:], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid Content-Range %q", contentRange)
+	}
+	return size, nil
+}
+
+func (r *rangedReader) start() {
+	ctx, cancel := context.WithCancel(r.ctx)
+	r.cancel = cancel
+
+	// the queue capacity bounds the chunks fetched ahead of the reader
+	queue := make(chan chan chunkResult, r.concurrency)
+	r.queue = queue
+
+	from := r.offset
+	if from == 0 && r.first != nil {
+		r.buf, from = r.first, int64(len(r.first))
+	}
+	r.first = nil
+
+	go func() {
+		defer close(queue)
+		for off := from; off < r.size; off += r.chunkSize {
+			end := min(off+r.chunkSize, r.size)
+			ch := make(chan chunkResult, 1)
+			select {
+			case queue <- ch:
+			case <-ctx.Done():
+				return
+			}
+			go func() {
+				data, err := r.fetch(ctx, off, end)
+				ch <- chunkResult{data: data, err: err}
+			}()
+		}
+	}()
+}
+
+func (r *rangedReader) stop() {
+	if r.cancel != nil {
+		r.cancel()
+	}
+	r.cancel = nil
+	r.queue = nil
+	r.buf = nil
+}
+
+// fetch reads [off, end) pinned to the ETag seen at open, so a concurrent
+// overwrite fails the read instead of mixing object versions.
+func (r *rangedReader) fetch(ctx context.Context, off, end int64) ([]byte, error) {
+	opts := minio.GetObjectOptions{}
+	if err := opts.SetRange(off, end-1); err != nil {
+		return nil, err
+	}
+	if r.etag != "" {
+		if err := opts.SetMatchETag(r.etag); err != nil {
+			return nil, err
+		}
+	}
+
+	obj, err := r.client.GetObject(ctx, r.bucket, r.key, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Close() // nolint: errcheck
+
+	data := make([]byte, end-off)
+	if _, err := io.ReadFull(obj, data); err != nil {
+		return nil, fmt.Errorf("read range %d-%d: %w", off, end-1, err)
+	}
+	return data, nil
+}
+
+func (r *rangedReader) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+	if r.queue == nil {
+		r.start()
+	}
+
+	if len(r.buf) == 0 {
+		ch, ok := <-r.queue
+		if !ok {
+			return 0, io.ErrUnexpectedEOF
+		}
+		res := <-ch
+		if res.err != nil {
+			r.stop()
+			return 0, res.err
+		}
+		r.buf = res.data
+	}
+
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	r.offset += int64(n)
+	return n, nil
+}
+
+func (r *rangedReader) Seek(offset int64, whence int) (int64, error) {
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = r.offset + offset
+	case io.SeekEnd:
+		abs = r.size + offset
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if abs < 0 {
+		return 0, errors.New("negative position")
+	}
+
+	if abs != r.offset {
+		r.stop()
+		r.offset = abs
+	}
+	return abs, nil
+}
+
+func (r *rangedReader) Close() error {
+	r.stop()
+	r.first = nil
+	return nil
+}
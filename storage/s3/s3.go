@@ -4,14 +4,22 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/minio/minio-go/v7"
-	"github.com/minio/minio-go/v7/pkg/credentials"
 	"github.com/web3tea/piecehub/config"
+	"github.com/web3tea/piecehub/internal/logging"
+)
+
+const (
+	// checkTimeout bounds the bucket check done when a storage is created.
+	checkTimeout = 10 * time.Second
+
+	// maxCopySize is the largest object S3 copies in a single request.
+	maxCopySize = 5 << 30
 )
 
 type S3Storage struct {
@@ -20,23 +28,52 @@ type S3Storage struct {
 }
 
 func New(cfg *config.S3Config) (*S3Storage, error) {
+	transport, err := newTransport(cfg)
+	if err != nil {
+		return nil, err
+	}
+
 	mc, err := minio.New(cfg.Endpoint, &minio.Options{
-		Creds:  credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, ""),
-		Secure: cfg.UseSSL,
+		Creds:        newCredentials(cfg),
+		Secure:       cfg.UseSSL,
+		Region:       cfg.Region,
+		BucketLookup: bucketLookup(cfg.BucketLookup),
+		Transport:    transport,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to create s3 client: %w", err)
 	}
-	return &S3Storage{
+	s := &S3Storage{
 		cfg:    cfg,
 		client: mc,
-	}, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), checkTimeout)
+	defer cancel()
+	if err := s.Check(ctx); err != nil {
+		return nil, err
+	}
+
+	return s, nil
 }
 
 func (s *S3Storage) Name() string {
 	return s.cfg.Name
 }
 
+// Check implements storage.Storage. The bucket must exist and be
+// accessible with the configured credentials.
+func (s *S3Storage) Check(ctx context.Context) error {
+	ok, err := s.client.BucketExists(ctx, s.cfg.Bucket)
+	if err != nil {
+		return fmt.Errorf("check bucket %s: %w", s.cfg.Bucket, err)
+	}
+	if !ok {
+		return fmt.Errorf("bucket %s does not exist", s.cfg.Bucket)
+	}
+	return nil
+}
+
 func (s *S3Storage) Stats(ctx context.Context, name string) (int64, error) {
 	info, err := s.client.StatObject(ctx, s.cfg.Bucket, s.fileName(name), minio.StatObjectOptions{})
 	if err != nil {
@@ -45,11 +82,63 @@ func (s *S3Storage) Stats(ctx context.Context, name string) (int64, error) {
 	return info.Size, nil
 }
 
+// List implements storage.Storage. Only objects directly under the prefix
+// are pieces; hidden objects are skipped.
+func (s *S3Storage) List(ctx context.Context, fn func(name string, size int64) error) error {
+	prefix := ""
+	if s.cfg.Prefix != "" {
+		prefix = strings.TrimSuffix(s.cfg.Prefix, "/") + "/"
+	}
+	for obj := range s.client.ListObjects(ctx, s.cfg.Bucket, minio.ListObjectsOptions{Prefix: prefix}) {
+		if obj.Err != nil {
+			return fmt.Errorf("failed to list pieces: %w", obj.Err)
+		}
+		name := strings.TrimPrefix(obj.Key, prefix)
+		if name == "" || strings.HasSuffix(name, "/") || strings.HasPrefix(name, ".") {
+			continue
+		}
+		if err := fn(name, obj.Size); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (s *S3Storage) Delete(ctx context.Context, name string) error {
 	return s.client.RemoveObject(ctx, s.cfg.Bucket, s.fileName(name), minio.RemoveObjectOptions{})
 }
 
+// Rename implements storage.Storage. S3 has no rename, so the object is
+// copied server side and the source removed. Objects over the 5 GiB limit
+// of a single copy are copied in parts.
+func (s *S3Storage) Rename(ctx context.Context, from, to string) error {
+	info, err := s.client.StatObject(ctx, s.cfg.Bucket, s.fileName(from), minio.StatObjectOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to stat piece: %w", err)
+	}
+
+	dst := minio.CopyDestOptions{Bucket: s.cfg.Bucket, Object: s.fileName(to)}
+	src := minio.CopySrcOptions{Bucket: s.cfg.Bucket, Object: s.fileName(from), MatchETag: info.ETag}
+	if info.Size <= maxCopySize {
+		_, err = s.client.CopyObject(ctx, dst, src)
+	} else {
+		_, err = s.client.ComposeObject(ctx, dst, src)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to copy piece: %w", err)
+	}
+	return s.client.RemoveObject(ctx, s.cfg.Bucket, s.fileName(from), minio.RemoveObjectOptions{})
+}
+
 func (s *S3Storage) Read(ctx context.Context, name string) (io.ReadSeekCloser, error) {
+	if s.cfg.ReadConcurrency > 1 {
+		r, err := openRangedReader(ctx, s.client, s.cfg.Bucket, s.fileName(name), s.readChunkSize(), s.cfg.ReadConcurrency)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read piece: %w", err)
+		}
+		return r, nil
+	}
+
 	mo, err := s.client.GetObject(ctx, s.cfg.Bucket, s.fileName(name), minio.GetObjectOptions{})
 	if err != nil {
 		return nil, fmt.Errorf("failed to read piece: %w", err)
@@ -57,11 +146,17 @@ func (s *S3Storage) Read(ctx context.Context, name string) (io.ReadSeekCloser, e
 	return mo, nil
 }
 
-// CopyToHTTP implements storage.Storage.
+// CopyToHTTP implements storage.Storage. Only full-piece requests use the
+// parallel reader; range requests are served by a single stream so small
+// ranges do not prefetch whole chunks.
 func (s *S3Storage) CopyToHTTP(ctx context.Context, name string, w http.ResponseWriter, req *http.Request) error {
-	http.ServeFile(w, req, name)
-
-	obj, err := s.Read(ctx, name)
+	var obj io.ReadSeekCloser
+	var err error
+	if req.Header.Get("Range") == "" {
+		obj, err = s.Read(ctx, name)
+	} else {
+		obj, err = s.client.GetObject(ctx, s.cfg.Bucket, s.fileName(name), minio.GetObjectOptions{})
+	}
 	if err != nil {
 		return fmt.Errorf("failed to read piece: %w", err)
 	}
@@ -71,12 +166,45 @@ func (s *S3Storage) CopyToHTTP(ctx context.Context, name string, w http.Response
 	return nil
 }
 
-func (s *S3Storage) Write(ctx context.Context, name string, reader io.Reader) error {
-	info, err := s.client.PutObject(ctx, s.cfg.Bucket, s.fileName(name), reader, -1, minio.PutObjectOptions{})
+func (s *S3Storage) readChunkSize() int64 {
+	if s.cfg.ReadChunkSize > 0 {
+		return s.cfg.ReadChunkSize
+	}
+	return defaultReadChunkSize
+}
+
+// Write implements storage.Storage. Pieces of known size larger than a
+// part are uploaded with writeMultipart, which resumes an earlier attempt
+// at the same name. Otherwise the client buffers parts in memory, using
+// UploadConcurrency buffers of PartSize bytes, and aborts the upload if
+// it fails.
+func (s *S3Storage) Write(ctx context.Context, name string, reader io.Reader, size int64) error {
+	key := s.fileName(name)
+	// OptimalPartInfo rejects part sizes larger than the piece
+	if size >= 0 && size > int64(s.cfg.PartSize) {
+		_, partSize, _, err := minio.OptimalPartInfo(size, s.cfg.PartSize)
+		if err != nil {
+			return fmt.Errorf("failed to write piece: %w", err)
+		}
+		if size > partSize {
+			if err := s.writeMultipart(ctx, key, reader, size, partSize); err != nil {
+				return fmt.Errorf("failed to write piece: %w", err)
+			}
+			logging.FromContext(ctx).Debug("wrote piece", "storage", s.cfg.Name, "key", key, "size", size)
+			return nil
+		}
+	}
+
+	opts := minio.PutObjectOptions{
+		PartSize:              s.cfg.PartSize,
+		NumThreads:            s.cfg.UploadConcurrency,
+		ConcurrentStreamParts: size < 0 && s.cfg.UploadConcurrency > 1,
+	}
+	info, err := s.client.PutObject(ctx, s.cfg.Bucket, key, reader, size, opts)
 	if err != nil {
 		return fmt.Errorf("failed to write piece: %w", err)
 	}
-	log.Default().Printf("wrote piece %s: %d", s.fileName(name), info.Size)
+	logging.FromContext(ctx).Debug("wrote piece", "storage", s.cfg.Name, "key", key, "size", info.Size)
 	return nil
 }
 
@@ -86,3 +214,35 @@ func (s *S3Storage) fileName(name string) string {
 	}
 	return filepath.Join(s.cfg.Prefix, name)
 }
+
+// IncompleteUpload is a multipart upload that was started but never
+// completed or aborted.
+type IncompleteUpload struct {
+	Key       string
+	UploadID  string
+	Initiated time.Time
+}
+
+// AbortIncompleteUploads aborts multipart uploads under the prefix started
+// before olderThan ago. With dryRun it only lists them.
+func (s *S3Storage) AbortIncompleteUploads(ctx context.Context, olderThan time.Duration, dryRun bool) ([]IncompleteUpload, error) {
+	core := minio.Core{Client: s.client}
+	cutoff := time.Now().Add(-olderThan)
+
+	var aborted []IncompleteUpload
+	for info := range s.client.ListIncompleteUploads(ctx, s.cfg.Bucket, s.cfg.Prefix, true) {
+		if info.Err != nil {
+			return aborted, fmt.Errorf("list incomplete uploads: %w", info.Err)
+		}
+		if info.Initiated.After(cutoff) {
+			continue
+		}
+		if !dryRun {
+			if err := core.AbortMultipartUpload(ctx, s.cfg.Bucket, info.Key, info.UploadID); err != nil {
+				return aborted, fmt.Errorf("abort upload %s of %s: %w", info.UploadID, info.Key, err)
+			}
+		}
+		aborted = append(aborted, IncompleteUpload{Key: info.Key, UploadID: info.UploadID, Initiated: info.Initiated})
+	}
+	return aborted, nil
+}
@@ -4,11 +4,15 @@ import (
 	"context"
 	"io"
 	"net/http"
+
+	"github.com/web3tea/piecehub/config"
 )
 
 type Common interface {
 	Read(ctx context.Context, name string) (io.ReadSeekCloser, error)
-	Write(ctx context.Context, name string, reader io.Reader) error
+	// Write stores a piece. size is the exact length of reader, or -1 if
+	// unknown; backends use it to plan uploads.
+	Write(ctx context.Context, name string, reader io.Reader, size int64) error
 	Stats(ctx context.Context, name string) (int64, error)
 	Delete(ctx context.Context, name string) error
 	CopyToHTTP(ctx context.Context, name string, w http.ResponseWriter, req *http.Request) error
@@ -16,11 +20,24 @@ type Common interface {
 
 type Storage interface {
 	Name() string
+	// Check reports whether the backend is reachable and usable.
+	Check(ctx context.Context) error
+	// List calls fn for every piece in the storage, stopping at the first
+	// error fn returns.
+	List(ctx context.Context, fn func(name string, size int64) error) error
+	// Rename moves a piece to a new name, replacing any piece there.
+	Rename(ctx context.Context, from, to string) error
 	Common
 }
 
 type Manager interface {
 	Common
+	Locate(ctx context.Context, name string) (Storage, int64, error)
+	// Invalidate forgets the cached location of a piece that was changed
+	// on a storage directly.
+	Invalidate(name string)
 	GetStorage(name string) (Storage, error)
 	ListStorages() []string
+	Update(cfg *config.Config) (*UpdateResult, error)
+	Check(ctx context.Context) map[string]error
 }
@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"net/http"
+
+	"github.com/web3tea/piecehub/internal/tracing"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracedStorage records a span around every backend call.
+type tracedStorage struct {
+	Storage
+}
+
+func traced(s Storage) Storage {
+	return &tracedStorage{Storage: s}
+}
+
+// Unwrap returns the underlying backend.
+func (t *tracedStorage) Unwrap() Storage {
+	return t.Storage
+}
+
+func (t *tracedStorage) start(ctx context.Context, op, name string) (context.Context, trace.Span) {
+	return tracing.Tracer().Start(ctx, "storage."+op, trace.WithAttributes(
+		tracing.AttrStorage.String(t.Storage.Name()),
+		tracing.AttrPieceCID.String(name),
+	))
+}
+
+func (t *tracedStorage) Check(ctx context.Context) error {
+	ctx, span := tracing.Tracer().Start(ctx, "storage.Check", trace.WithAttributes(
+		tracing.AttrStorage.String(t.Storage.Name()),
+	))
+	defer span.End()
+	return record(span, t.Storage.Check(ctx))
+}
+
+func (t *tracedStorage) List(ctx context.Context, fn func(name string, size int64) error) error {
+	ctx, span := tracing.Tracer().Start(ctx, "storage.List", trace.WithAttributes(
+		tracing.AttrStorage.String(t.Storage.Name()),
+	))
+	defer span.End()
+	return record(span, t.Storage.List(ctx, fn))
+}
+
+func (t *tracedStorage) Read(ctx context.Context, name string) (io.ReadSeekCloser, error) {
+	ctx, span := t.start(ctx, "Read", name)
+	defer span.End()
+	r, err := t.Storage.Read(ctx, name)
+	return r, record(span, err)
+}
+
+func (t *tracedStorage) Write(ctx context.Context, name string, reader io.Reader, size int64) error {
+	ctx, span := t.start(ctx, "Write", name)
+	defer span.End()
+	return record(span, t.Storage.Write(ctx, name, reader, size))
+}
+
+func (t *tracedStorage) Rename(ctx context.Context, from, to string) error {
+	ctx, span := t.start(ctx, "Rename", to)
+	defer span.End()
+	return record(span, t.Storage.Rename(ctx, from, to))
+}
+
+func (t *tracedStorage) Stats(ctx context.Context, name string) (int64, error) {
+	ctx, span := t.start(ctx, "Stats", name)
+	defer span.End()
+	size, err := t.Storage.Stats(ctx, name)
+	return size, record(span, err)
+}
+
+func (t *tracedStorage) Delete(ctx context.Context, name string) error {
+	ctx, span := t.start(ctx, "Delete", name)
+	defer span.End()
+	return record(span, t.Storage.Delete(ctx, name))
+}
+
+func (t *tracedStorage) CopyToHTTP(ctx context.Context, name string, w http.ResponseWriter, req *http.Request) error {
+	ctx, span := t.start(ctx, "CopyToHTTP", name)
+	defer span.End()
+	return record(span, t.Storage.CopyToHTTP(ctx, name, w, req))
+}
+
+func record(span trace.Span, err error) error {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return err
+}